@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// The most operations a single batch may contain.
+const maxBatchOperations = 50
+
+// Where the movie handlers read & write movies: the model, or the transaction of an atomic batch.
+type movieStore interface {
+	Get(id int64) (*data.Movie, error)
+	Insert(movie *data.Movie) error
+	Update(movie *data.Movie) error
+	// A version of 0 deletes the movie whatever its version.
+	DeleteVersion(id int64, version int32) error
+	FindDuplicates(movie *data.Movie, threshold float64) ([]*data.Movie, error)
+}
+
+func (app *application) movieStore(r *http.Request) movieStore {
+	if store := app.contextGetMovieStore(r); store != nil {
+		return store
+	}
+
+	return app.models.Movies
+}
+
+// A single operation of a batch.
+type batchOperation struct {
+	Method  string          `json:"method"`   // "create", "update" or "delete"
+	ID      int64           `json:"id"`       // of the movie to update or delete
+	IfMatch int32           `json:"if_match"` // the version the movie must still be at; optional
+	Movie   json.RawMessage `json:"movie"`    // the request body of a create or update
+	// Create only: like the allow_duplicate parameter of POST /v1/movies.
+	AllowDuplicate bool `json:"allow_duplicate"`
+}
+
+// The outcome of an operation: the status & body the single-item endpoint would have responded with.
+type batchResult struct {
+	Status   int             `json:"status"`
+	Location string          `json:"location,omitempty"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// Captures the response of an operation.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *batchResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *batchResponseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+}
+
+func (rw *batchResponseWriter) Write(b []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	return rw.body.Write(b)
+}
+
+// corresponding endpoint: "POST /v1/batch"
+// Runs several movie operations in one request, e.g.
+//
+//	{"atomic": true, "operations": [
+//		{"method": "create", "movie": {"title": "Heat", "year": 1995, "runtime": 170, "genres": ["crime"]}},
+//		{"method": "update", "id": 12, "if_match": 3, "movie": {"year": 1994}},
+//		{"method": "delete", "id": 7}
+//	]}
+//
+// Each operation goes through the same handler as its single-item endpoint (POST /v1/movies,
+// PATCH & DELETE /v1/movies/:id), so it's validated the same & its result has the same shape.
+// "if_match" works like the X-Expected-Version header of those endpoints.
+//
+// By default the operations are independent: each one succeeds or fails on its own. Atomic batches
+// run in a single transaction & stop at the first failing operation; nothing is committed then, &
+// the other operations report 424 Failed Dependency.
+//
+// Requires the "movies:write" permission.
+func (app *application) batchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Atomic     bool             `json:"atomic"`
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Operations) >= 1, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+
+	for i, op := range input.Operations {
+		key := fmt.Sprintf("operations[%d]", i)
+		hasMovie := len(op.Movie) > 0 && string(op.Movie) != "null"
+
+		v.Check(validator.PermittedValue(op.Method, "create", "update", "delete"), key+".method", "must be create, update or delete")
+
+		switch op.Method {
+		case "create":
+			v.Check(op.ID == 0, key+".id", "must not be provided for create")
+			v.Check(op.IfMatch == 0, key+".if_match", "must not be provided for create")
+			v.Check(hasMovie, key+".movie", "must be provided")
+		case "update":
+			v.Check(op.ID >= 1, key+".id", "must be a positive integer")
+			v.Check(op.IfMatch >= 0, key+".if_match", "must be a positive integer")
+			v.Check(hasMovie, key+".movie", "must be provided")
+			v.Check(!op.AllowDuplicate, key+".allow_duplicate", "must only be provided for create")
+		case "delete":
+			v.Check(op.ID >= 1, key+".id", "must be a positive integer")
+			v.Check(op.IfMatch >= 0, key+".if_match", "must be a positive integer")
+			v.Check(!hasMovie, key+".movie", "must not be provided for delete")
+			v.Check(!op.AllowDuplicate, key+".allow_duplicate", "must only be provided for create")
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]batchResult, len(input.Operations))
+
+	if !input.Atomic {
+		for i, op := range input.Operations {
+			results[i] = app.runBatchOperation(r, op)
+		}
+
+		err = app.writeResponse(w, r, envelope{"results": results}, http.StatusOK, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tx, err := app.models.Movies.Begin()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	txRequest := app.contextSetMovieStore(r, tx)
+	failed := -1
+
+	for i, op := range input.Operations {
+		results[i] = app.runBatchOperation(txRequest, op)
+
+		if results[i].Status >= 400 {
+			failed = i
+			break
+		}
+	}
+
+	if failed >= 0 {
+		for i := range results {
+			switch {
+			case i < failed:
+				results[i] = failedDependencyResult(fmt.Sprintf("rolled back because operation %d failed", failed))
+			case i > failed:
+				results[i] = failedDependencyResult(fmt.Sprintf("not attempted because operation %d failed", failed))
+			}
+		}
+	} else {
+		err = tx.Commit()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeResponse(w, r, envelope{"results": results, "committed": failed < 0}, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Runs an operation through the handler of its single-item endpoint, as if it had been requested
+// on its own (but always answered in JSON, to be embedded in the batch response).
+func (app *application) runBatchOperation(r *http.Request, op batchOperation) batchResult {
+	var method, path string
+	var handler http.HandlerFunc
+
+	switch op.Method {
+	case "create":
+		method, path, handler = http.MethodPost, "/v1/movies", app.createMovieHandler
+		if op.AllowDuplicate {
+			path += "?allow_duplicate=true"
+		}
+	case "update":
+		method, path, handler = http.MethodPatch, fmt.Sprintf("/v1/movies/%d", op.ID), app.updateMovieHandler
+	case "delete":
+		method, path, handler = http.MethodDelete, fmt.Sprintf("/v1/movies/%d", op.ID), app.deleteMovieHandler
+	}
+
+	// The handlers read the id from the route parameters, as set by the router.
+	params := httprouter.Params{{Key: "id", Value: strconv.FormatInt(op.ID, 10)}}
+	ctx := context.WithValue(r.Context(), httprouter.ParamsKey, params)
+
+	sub, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(op.Movie))
+	if err != nil {
+		panic(err) // the method & path are always valid
+	}
+	sub.Header.Set("Content-Type", "application/json")
+
+	if op.IfMatch > 0 {
+		sub.Header.Set("X-Expected-Version", strconv.FormatInt(int64(op.IfMatch), 10))
+	}
+
+	sub = app.contextSetFormats(sub, []*responseFormat{jsonFormat})
+
+	rw := &batchResponseWriter{header: make(http.Header)}
+	handler(rw, sub)
+
+	result := batchResult{
+		Status:   rw.status,
+		Location: rw.header.Get("Location"),
+		Body:     bytes.TrimSpace(rw.body.Bytes()),
+	}
+
+	if len(result.Body) == 0 {
+		result.Body = nil
+	}
+
+	return result
+}
+
+func failedDependencyResult(message string) batchResult {
+	body, _ := json.Marshal(envelope{"error": message})
+	return batchResult{Status: http.StatusFailedDependency, Body: body}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// N.B. only the paths which don't reach the database are covered here.
+func TestBatchHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string // a substring of the (compacted) response body
+	}{
+		{
+			name:   "no operations",
+			body:   `{"operations": []}`,
+			status: http.StatusUnprocessableEntity,
+			want:   `"operations":"must contain at least 1 operation"`,
+		},
+		{
+			name:   "invalid operation",
+			body:   `{"operations": [{"method": "delete", "id": 1}, {"method": "update", "movie": {"year": 1994}}]}`,
+			status: http.StatusUnprocessableEntity,
+			want:   `"operations[1].id":"must be a positive integer"`,
+		},
+		{
+			// The operation's body is rejected by the create handler itself.
+			name:   "failing operation",
+			body:   `{"operations": [{"method": "create", "movie": {"title": "Heat", "rating": 5}}]}`,
+			status: http.StatusOK,
+			want:   `"results":[{"status":400,"body":{"error":"body contains unknown key \"\\\"rating\\\"\""}}]`,
+		},
+	}
+
+	app := &application{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/batch", strings.NewReader(tt.body))
+
+			app.batchHandler(rr, r)
+
+			if rr.Code != tt.status {
+				t.Errorf("status = %d; want %d", rr.Code, tt.status)
+			}
+
+			var body bytes.Buffer
+			err := json.Compact(&body, rr.Body.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(body.String(), tt.want) {
+				t.Errorf("body = %s; want it to contain %s", body.String(), tt.want)
+			}
+		})
+	}
+}
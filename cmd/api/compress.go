@@ -0,0 +1,203 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Bodies smaller than this aren't worth compressing; the gzip overhead can even make them bigger.
+const minCompressSize = 1024
+
+// Content types which are already compressed (or streamed) & are sent as-is.
+var uncompressibleTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/gzip", "application/x-gzip", "application/zip", "application/zstd", "application/pdf",
+	"text/event-stream",
+}
+
+// gzip.Writers are relatively expensive to create, so they're reused across responses.
+var gzipWriterPool = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// Gzip-compresses the response body if the client accepts it (via the Accept-Encoding header).
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// e.g. "gzip, deflate, br" or "br;q=1.0, gzip;q=0.8, *;q=0.1"
+func acceptsGzip(header string) bool {
+	accepted := false
+
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		q := 1.0
+		if key, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(key) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err == nil {
+				q = parsed
+			}
+		}
+
+		switch coding {
+		case "gzip", "x-gzip":
+			// An explicit gzip entry overrides any wildcard.
+			return q > 0
+		case "*":
+			accepted = q > 0
+		}
+	}
+
+	return accepted
+}
+
+// Buffers the start of the body, so the compression decision can be made once
+// the status, the Content-Type & (roughly) the size of the body are known.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	started bool // whether the headers have been sent
+	gz      *gzip.Writer
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	// Informational responses (e.g. 103 Early Hints) go straight through.
+	if status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.started {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < minCompressSize {
+			return len(p), nil
+		}
+
+		return len(p), cw.start(true)
+	}
+
+	if cw.gz != nil {
+		return cw.gz.Write(p)
+	}
+
+	return cw.ResponseWriter.Write(p)
+}
+
+// Sends the headers (deciding whether to compress) & whatever has been buffered so far.
+func (cw *compressResponseWriter) start(large bool) error {
+	cw.started = true
+
+	if large && cw.compressible() {
+		cw.Header().Set("Content-Encoding", "gzip")
+		// The length of the compressed body isn't known up front.
+		cw.Header().Del("Content-Length")
+
+		cw.gz = gzipWriterPool.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if cw.gz != nil {
+		_, err := cw.gz.Write(buf)
+		return err
+	}
+
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+func (cw *compressResponseWriter) compressible() bool {
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+
+	// e.g. a handler serving a file which is already gzipped.
+	if cw.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := strings.ToLower(cw.Header().Get("Content-Type"))
+	for _, prefix := range uncompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Supports streaming handlers: whatever is buffered is sent (uncompressed if it's still small).
+func (cw *compressResponseWriter) Flush() {
+	if !cw.started {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.start(len(cw.buf) >= minCompressSize)
+	}
+
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Lets http.ResponseController reach the underlying ResponseWriter.
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Sends anything still buffered (e.g. a small body) & finishes the gzip stream.
+func (cw *compressResponseWriter) close() {
+	if !cw.started {
+		// Nothing was written at all; leave the defaults to net/http.
+		if cw.status == 0 {
+			return
+		}
+		cw.start(false)
+	}
+
+	if cw.gz != nil {
+		cw.gz.Close()
+		gzipWriterPool.Put(cw.gz)
+		cw.gz = nil
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"x-gzip", true},
+		{"deflate, br", false},
+		{"gzip, deflate, br", true},
+		{"br;q=1.0, gzip;q=0.8, *;q=0.1", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"*", true},
+		{"*;q=0", false},
+		// An explicit gzip entry overrides the wildcard, in either order.
+		{"*, gzip;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*;q=0, gzip", true},
+		{"identity", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %t; want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"title": "Heat"}`, 100) // over minCompressSize
+	small := `{"title": "Heat"}`
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		status         int
+		body           string
+		compressed     bool
+	}{
+		{name: "large", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusOK, body: large, compressed: true},
+		{name: "wildcard", acceptEncoding: "*", contentType: "application/json", status: http.StatusOK, body: large, compressed: true},
+		{name: "error status", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusNotFound, body: large, compressed: true},
+		{name: "just below the threshold", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusOK, body: large[:minCompressSize-1]},
+		{name: "small", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusOK, body: small},
+		{name: "not accepted", acceptEncoding: "", contentType: "application/json", status: http.StatusOK, body: large},
+		{name: "gzip refused", acceptEncoding: "gzip;q=0, deflate", contentType: "application/json", status: http.StatusOK, body: large},
+		{name: "image", acceptEncoding: "gzip", contentType: "image/png", status: http.StatusOK, body: large},
+		{name: "event stream", acceptEncoding: "gzip", contentType: "text/event-stream", status: http.StatusOK, body: large},
+		{name: "zip", acceptEncoding: "gzip", contentType: "application/zip", status: http.StatusOK, body: large},
+		{name: "no content", acceptEncoding: "gzip", status: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{}
+
+			handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+
+				// Written in pieces, like a handler streaming its output.
+				for body := tt.body; body != ""; {
+					n := min(len(body), 100)
+					w.Write([]byte(body[:n]))
+					body = body[n:]
+				}
+			}))
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != tt.status {
+				t.Errorf("status = %d; want %d", rr.Code, tt.status)
+			}
+
+			// Caches must keep the compressed & plain variants apart, whichever was sent.
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q; want Accept-Encoding", got)
+			}
+
+			body := rr.Body.Bytes()
+
+			if got := rr.Header().Get("Content-Encoding"); (got == "gzip") != tt.compressed {
+				t.Fatalf("Content-Encoding = %q; compressed should be %t", got, tt.compressed)
+			}
+
+			if tt.compressed {
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				body, err = io.ReadAll(gz)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if string(body) != tt.body {
+				t.Errorf("body = %q; want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+// Handlers serving content which is already encoded are left alone.
+func TestCompressEncodedContent(t *testing.T) {
+	app := &application{}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(strings.Repeat("a", 2*minCompressSize)))
+	gz.Close()
+
+	handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(buf.Bytes())
+	}))
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	handler.ServeHTTP(rr, r)
+
+	if !bytes.Equal(rr.Body.Bytes(), buf.Bytes()) {
+		t.Error("the already gzipped body was compressed again")
+	}
+}
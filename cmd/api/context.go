@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+)
+
+// A custom type for the request context keys, to avoid collisions with other packages.
+type contextKey string
+
+const (
+	userContextKey       = contextKey("user")
+	formatsContextKey    = contextKey("formats")
+	movieStoreContextKey = contextKey("movieStore")
+)
+
+// Returns a copy of the request with the given User added to its context.
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// Retrieves the User from the request context.
+// The *authenticate* middleware always sets one, so a missing value is unexpected & we panic.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// Returns a copy of the request with the acceptable response formats (best first) added to its context.
+func (app *application) contextSetFormats(r *http.Request, formats []*responseFormat) *http.Request {
+	ctx := context.WithValue(r.Context(), formatsContextKey, formats)
+	return r.WithContext(ctx)
+}
+
+// Retrieves the acceptable response formats from the request context.
+// Unlike the user these may be missing (e.g. when the *negotiate* middleware itself responds); JSON is used then.
+func (app *application) contextGetFormats(r *http.Request) []*responseFormat {
+	formats, ok := r.Context().Value(formatsContextKey).([]*responseFormat)
+	if !ok {
+		return []*responseFormat{jsonFormat}
+	}
+
+	return formats
+}
+
+// Returns a copy of the request with the given movie store (e.g. the transaction of an atomic
+// batch) added to its context; the movie handlers then read & write through it (see movieStore()).
+func (app *application) contextSetMovieStore(r *http.Request, store movieStore) *http.Request {
+	ctx := context.WithValue(r.Context(), movieStoreContextKey, store)
+	return r.WithContext(ctx)
+}
+
+// Retrieves the movie store from the request context; nil if there's none.
+func (app *application) contextGetMovieStore(r *http.Request) movieStore {
+	store, _ := r.Context().Value(movieStoreContextKey).(movieStore)
+	return store
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+)
+
+// A change of the catalog, as POSTed to the webhooks & sent to the event stream.
+// The ID is the same for every webhook delivery attempt, so receivers can ignore redeliveries.
+type changeEvent struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Runs fn in a new goroutine; a panic is logged instead of crashing the application.
+// The graceful shutdown waits for these goroutines to finish (see serve()).
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// Encodes an outbox event as it's POSTed to the webhooks & sent to the event stream.
+// The outbox id serves as the event ID.
+func newChangeEvent(e *data.OutboxEvent) (changeEvent, []byte, error) {
+	evt := changeEvent{
+		ID:        strconv.FormatInt(e.ID, 10),
+		Event:     e.Event,
+		CreatedAt: e.CreatedAt.UTC(),
+		Data:      e.Payload,
+	}
+
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return changeEvent{}, nil, err
+	}
+
+	return evt, body, nil
+}
+
+// Queues a delivery of the event to every webhook subscribed to it. The deliveries are recorded
+// with the "webhooks" checkpoint moving past the event, so none is lost if the process dies;
+// runWebhookDeliveries makes them.
+func (app *application) dispatchWebhooks(otx *data.OutboxTx, e *data.OutboxEvent) error {
+	evt, body, err := newChangeEvent(e)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Webhooks.QueueDeliveries(otx, evt.ID, evt.Event, body)
+}
+
+// Lets runWebhookDeliveries know that deliveries were queued (& committed).
+func (app *application) wakeWebhookDeliveries() {
+	select {
+	case app.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// The queued deliveries claimed (& attempted concurrently) per round.
+const webhookDeliveryBatchSize = 20
+
+// Makes the queued webhook deliveries until the shutdown starts, retrying the failed ones.
+// The delay doubles after every failed attempt, & every attempt is recorded in the delivery log.
+//
+// Nothing is abandoned on shutdown: the attempts in flight are finished (they're bounded by the
+// webhook timeout), & the deliveries not done yet stay queued for the next start (or another instance).
+func (app *application) runWebhookDeliveries() {
+	poll := time.NewTicker(app.config.outbox.pollInterval)
+	defer poll.Stop()
+
+	for {
+		// Drain whatever is due before waiting again.
+		for {
+			claimed := app.attemptWebhookDeliveries()
+			if claimed < webhookDeliveryBatchSize {
+				break
+			}
+
+			select {
+			case <-app.shutdown:
+				return
+			default:
+			}
+		}
+
+		select {
+		case <-app.webhookWake:
+		case <-poll.C:
+		case <-app.shutdown:
+			return
+		}
+	}
+}
+
+// Claims the deliveries which are due & attempts them, returning how many were claimed.
+func (app *application) attemptWebhookDeliveries() int {
+	// Long enough for all the attempts of the round to finish; if the process dies meanwhile,
+	// the deliveries are attempted again once it's over.
+	lease := 2*app.config.webhooks.timeout + 30*time.Second
+
+	deliveries, err := app.models.Webhooks.ClaimDeliveries(webhookDeliveryBatchSize, lease)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return 0
+	}
+
+	var wg sync.WaitGroup
+
+	for _, pending := range deliveries {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			app.attemptWebhookDelivery(pending)
+		}()
+	}
+
+	wg.Wait()
+
+	return len(deliveries)
+}
+
+// Makes one attempt at a queued delivery, then removes it from the queue (on success, or once the
+// attempts are used up) or schedules the next attempt.
+func (app *application) attemptWebhookDelivery(pending *data.PendingDelivery) {
+	cfg := app.config.webhooks
+	logger := app.logger.With("webhook_id", pending.WebhookID, "event_id", pending.EventID)
+
+	// Disabled since the event was queued.
+	if !pending.Active {
+		err := app.models.Webhooks.FinishDelivery(pending.ID)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+		return
+	}
+
+	webhook := &data.Webhook{ID: pending.WebhookID, URL: pending.URL, Secret: pending.Secret}
+
+	attempt := pending.Attempts + 1
+
+	delivery := app.sendWebhook(webhook, changeEvent{ID: pending.EventID, Event: pending.Event}, pending.Body)
+	delivery.Attempt = attempt
+
+	err := app.models.Webhooks.InsertDelivery(delivery)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	if !delivery.Succeeded && attempt < cfg.maxAttempts {
+		err = app.models.Webhooks.RetryDelivery(pending.ID, attempt, app.webhookRetryDelay(attempt))
+		if err != nil {
+			// The lease runs out & the attempt is made again.
+			logger.Error(err.Error())
+		}
+		return
+	}
+
+	err = app.models.Webhooks.FinishDelivery(pending.ID)
+	if err != nil {
+		// Attempted again once the lease is over; receivers ignore the redelivery (same event id).
+		logger.Error(err.Error())
+		return
+	}
+
+	disabled, err := app.models.Webhooks.RecordResult(webhook.ID, delivery.Succeeded, cfg.disableAfter)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	if disabled {
+		logger.Warn("webhook disabled after repeated failures", "url", webhook.URL)
+	}
+}
+
+// The most attempts per delivery the -webhook-max-attempts flag allows.
+const maxWebhookAttempts = 20
+
+// The longest wait between two attempts, however many failed before.
+const maxWebhookRetryDelay = 6 * time.Hour
+
+// The delay after the given failed attempt (1 for the first): the retry delay, doubled after every
+// further failure, up to maxWebhookRetryDelay. Doubling step by step can't overflow, unlike a shift.
+func (app *application) webhookRetryDelay(attempt int) time.Duration {
+	delay := app.config.webhooks.retryDelay
+
+	for i := 1; i < attempt && delay < maxWebhookRetryDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxWebhookRetryDelay)
+}
+
+// Makes a single delivery attempt; anything but a 2xx response counts as a failure.
+func (app *application) sendWebhook(webhook *data.Webhook, evt changeEvent, body []byte) *data.WebhookDelivery {
+	delivery := &data.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   evt.ID,
+		Event:     evt.Event,
+	}
+
+	start := time.Now()
+	defer func() {
+		delivery.DurationMS = int(time.Since(start).Milliseconds())
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	timestamp := time.Now().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "movies-api-webhooks/"+version)
+	req.Header.Set("X-Webhook-Event", evt.Event)
+	req.Header.Set("X-Webhook-Delivery", evt.ID)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(webhook.Secret, timestamp, body))
+
+	resp, err := app.webhookClient.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+
+	// Read (a bit of) the body, so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	delivery.StatusCode = &resp.StatusCode
+	delivery.Succeeded = resp.StatusCode >= 200 && resp.StatusCode <= 299
+	if !delivery.Succeeded {
+		delivery.Error = resp.Status
+	}
+
+	return delivery
+}
+
+// The signature of a delivery: "sha256=" followed by the hex-encoded HMAC-SHA256 of
+// "<timestamp>.<body>", keyed with the webhook's secret. Receivers recompute it to verify
+// the event came from us, & check the timestamp to reject replays of old deliveries.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// The HTTP client for the deliveries. Redirects are NOT followed: a webhook must answer itself.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
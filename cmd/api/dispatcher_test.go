@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+)
+
+func TestSendWebhookSignature(t *testing.T) {
+	secret := "0123456789abcdef"
+	body := []byte(`{"id":"e1","event":"movie.created"}`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		if string(got) != string(body) {
+			t.Errorf("body = %s; want %s", got, body)
+		}
+
+		if r.Header.Get("X-Webhook-Event") != "movie.created" || r.Header.Get("X-Webhook-Delivery") != "e1" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+
+		// Verify the signature the way a receiver would.
+		timestamp, err := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := signWebhook(secret, timestamp, got)
+		if !hmac.Equal([]byte(r.Header.Get("X-Webhook-Signature")), []byte(want)) {
+			t.Errorf("signature = %q; want %q", r.Header.Get("X-Webhook-Signature"), want)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	app := &application{webhookClient: newWebhookClient(time.Second)}
+	webhook := &data.Webhook{ID: 1, URL: srv.URL, Secret: secret}
+
+	delivery := app.sendWebhook(webhook, changeEvent{ID: "e1", Event: "movie.created"}, body)
+
+	if !delivery.Succeeded || delivery.StatusCode == nil || *delivery.StatusCode != http.StatusNoContent {
+		t.Errorf("got %+v; want a successful delivery", delivery)
+	}
+}
+
+func TestSendWebhookFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{
+			name:    "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			status:  http.StatusInternalServerError,
+		},
+		{
+			// Redirects aren't followed.
+			name:    "redirect",
+			handler: func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/elsewhere", http.StatusFound) },
+			status:  http.StatusFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			app := &application{webhookClient: newWebhookClient(time.Second)}
+			delivery := app.sendWebhook(&data.Webhook{URL: srv.URL}, changeEvent{}, []byte("{}"))
+
+			if delivery.Succeeded || delivery.StatusCode == nil || *delivery.StatusCode != tt.status || delivery.Error == "" {
+				t.Errorf("got %+v; want a failed delivery with status %d", delivery, tt.status)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+
+		app := &application{webhookClient: newWebhookClient(time.Second)}
+		delivery := app.sendWebhook(&data.Webhook{URL: srv.URL}, changeEvent{}, []byte("{}"))
+
+		if delivery.Succeeded || delivery.StatusCode != nil || delivery.Error == "" {
+			t.Errorf("got %+v; want a failed delivery without status", delivery)
+		}
+	})
+}
+
+func TestNewChangeEvent(t *testing.T) {
+	e := &data.OutboxEvent{
+		ID:        42,
+		CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		Event:     "movie.deleted",
+		Payload:   []byte(`{"movie": {"id": 7}}`),
+	}
+
+	evt, body, err := newChangeEvent(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if evt.ID != "42" {
+		t.Errorf("ID = %q; want the outbox id", evt.ID)
+	}
+
+	want := `{"id":"42","event":"movie.deleted","created_at":"2024-05-01T10:00:00Z","data":{"movie":{"id":7}}}`
+	if string(body) != want {
+		t.Errorf("body = %s; want %s", body, want)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	app := &application{}
+	app.config.webhooks.retryDelay = 10 * time.Second
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{12, 10 * time.Second << 11},
+		{13, maxWebhookRetryDelay},
+		// Would overflow as retryDelay << (attempt - 1).
+		{100, maxWebhookRetryDelay},
+	}
+
+	for _, tt := range tests {
+		if got := app.webhookRetryDelay(tt.attempt); got != tt.want {
+			t.Errorf("attempt %d: got %v; want %v", tt.attempt, got, tt.want)
+		}
+	}
+
+	// Delays longer than the cap to begin with are capped too.
+	app.config.webhooks.retryDelay = 1000 * time.Hour
+	if got := app.webhookRetryDelay(1); got != maxWebhookRetryDelay {
+		t.Errorf("got %v; want %v", got, maxWebhookRetryDelay)
+	}
+}
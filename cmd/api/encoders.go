@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// The non-JSON encoders work on the JSON form of a response, decoded into a generic tree.
+// That way custom JSON encodings (e.g. data.Runtime => "102 mins") & struct tags
+// apply to every format alike, and object keys keep their JSON order.
+
+// A decoded JSON object; unlike a Go map it preserves the order of the keys.
+type jsonObject []jsonField
+
+type jsonField struct {
+	Key   string
+	Value any // jsonObject, []any, string, json.Number, bool or nil
+}
+
+// Converts a value into its generic tree form by way of its JSON encoding.
+func toTree(data any) (any, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	return decodeTree(dec)
+}
+
+func decodeTree(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := jsonObject{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+
+				value, err := decodeTree(dec)
+				if err != nil {
+					return nil, err
+				}
+
+				obj = append(obj, jsonField{Key: key.(string), Value: value})
+			}
+			_, err = dec.Token() // the closing '}'
+			return obj, err
+
+		case '[':
+			arr := []any{}
+			for dec.More() {
+				value, err := decodeTree(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, value)
+			}
+			_, err = dec.Token() // the closing ']'
+			return arr, err
+		}
+
+		return nil, fmt.Errorf("unexpected delimiter %q", t)
+
+	default:
+		// string, json.Number, bool or nil
+		return t, nil
+	}
+}
+
+// XML ========================================================================== #
+
+// e.g. {"movie": {"id": 1, "genres": ["drama"]}} =>
+// <response><movie><id>1</id><genres><item>drama</item></genres></movie></response>
+func encodeXML(tree any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+
+	err := writeXMLElement(enc, "response", tree)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func writeXMLElement(enc *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	// Keys which aren't valid element names (e.g. "1990s") are written as <entry key="1990s">.
+	if !isXMLName(name) {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+
+	if value == nil {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+	}
+
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case jsonObject:
+		for _, field := range v {
+			err = writeXMLElement(enc, field.Key, field.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+	case []any:
+		for _, item := range v {
+			err = writeXMLElement(enc, "item", item)
+			if err != nil {
+				return err
+			}
+		}
+
+	case nil:
+		// An empty element carrying nil="true".
+
+	default:
+		err = enc.EncodeToken(xml.CharData(scalarString(v)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+
+	for i, r := range name {
+		letter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_'
+		if i == 0 && !letter {
+			return false
+		}
+		if !letter && !(r >= '0' && r <= '9') && r != '-' && r != '.' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func scalarString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// MessagePack ================================================================== #
+
+// Encodes the tree following https://github.com/msgpack/msgpack/blob/master/spec.md
+// Integers use the smallest representation; other numbers become float64.
+func encodeMsgpack(tree any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := writeMsgpack(&buf, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeMsgpack(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+
+	case string:
+		writeMsgpackHeader(buf, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+
+	case []any:
+		writeMsgpackHeader(buf, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			err := writeMsgpack(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+
+	case jsonObject:
+		writeMsgpackHeader(buf, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, field := range v {
+			err := writeMsgpack(buf, field.Key)
+			if err != nil {
+				return err
+			}
+
+			err = writeMsgpack(buf, field.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", value)
+	}
+
+	return nil
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i)) // positive fixint
+	case i >= -32 && i < 0:
+		buf.WriteByte(byte(int8(i))) // negative fixint
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// Writes the type & length prefix of a string, array or map.
+// fix is the "fix" type (holding lengths up to fixMax); code8 may be 0 if there's no 8-bit variant.
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(code8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// CSV ========================================================================== #
+
+var errNotTabular = errors.New("response can't be represented as CSV")
+
+// Only lists (e.g. {"movies": [...], "metadata": {...}}) & errors have a tabular form.
+// Each list item becomes a row; nested objects are flattened into "movie.title" style columns
+// & arrays of plain values are joined with commas.
+func encodeCSV(tree any) ([]byte, error) {
+	obj, ok := tree.(jsonObject)
+	if !ok {
+		return nil, errNotTabular
+	}
+
+	var rows []any
+
+	for _, field := range obj {
+		switch {
+		case field.Key == "error":
+			rows = errorRows(field.Value)
+		default:
+			if list, ok := field.Value.([]any); ok && rows == nil {
+				rows = list
+			}
+		}
+	}
+
+	if rows == nil {
+		return nil, errNotTabular
+	}
+
+	var header []string
+	seen := make(map[string]bool)
+	records := make([]map[string]string, len(rows))
+
+	for i, row := range rows {
+		records[i] = make(map[string]string)
+
+		flattenCSV(records[i], "", row, func(column string) {
+			if !seen[column] {
+				seen[column] = true
+				header = append(header, column)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	w.Write(header)
+	for _, record := range records {
+		line := make([]string, len(header))
+		for i, column := range header {
+			line[i] = record[column]
+		}
+		w.Write(line)
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// An error message becomes a single row; a validation error map one row per field.
+func errorRows(value any) []any {
+	fields, ok := value.(jsonObject)
+	if !ok {
+		return []any{jsonObject{{Key: "error", Value: value}}}
+	}
+
+	rows := make([]any, len(fields))
+	for i, field := range fields {
+		rows[i] = jsonObject{{Key: "field", Value: field.Key}, {Key: "error", Value: field.Value}}
+	}
+
+	return rows
+}
+
+func flattenCSV(record map[string]string, prefix string, value any, addColumn func(string)) {
+	column := prefix
+	if column == "" {
+		column = "value"
+	}
+
+	switch v := value.(type) {
+	case jsonObject:
+		for _, field := range v {
+			key := field.Key
+			if prefix != "" {
+				key = prefix + "." + field.Key
+			}
+			flattenCSV(record, key, field.Value, addColumn)
+		}
+		return
+
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, nested := item.(jsonObject); nested {
+				js, _ := json.Marshal(unTree(item))
+				parts = append(parts, string(js))
+				continue
+			}
+			parts = append(parts, scalarString(item))
+		}
+		record[column] = strings.Join(parts, ",")
+
+	default:
+		record[column] = scalarString(v)
+	}
+
+	addColumn(column)
+}
+
+// Turns a tree back into plain Go values (for encoding nested objects as JSON text).
+func unTree(value any) any {
+	switch v := value.(type) {
+	case jsonObject:
+		m := make(map[string]any, len(v))
+		for _, field := range v {
+			m[field.Key] = unTree(field.Value)
+		}
+		return m
+	case []any:
+		arr := make([]any, len(v))
+		for i, item := range v {
+			arr[i] = unTree(item)
+		}
+		return arr
+	default:
+		return v
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+)
+
+func TestToTree(t *testing.T) {
+	tree, err := toTree(envelope{"movie": struct {
+		Title   string       `json:"title"`
+		Runtime data.Runtime `json:"runtime"`
+		Rating  float64      `json:"rating"`
+		Poster  *string      `json:"poster"`
+	}{Title: "Heat", Runtime: 170, Rating: 4.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movie := tree.(jsonObject)[0].Value.(jsonObject)
+
+	// The keys keep the struct order; custom JSON encodings apply.
+	var keys []string
+	for _, field := range movie {
+		keys = append(keys, field.Key)
+	}
+	if got := strings.Join(keys, ","); got != "title,runtime,rating,poster" {
+		t.Errorf("keys = %s; want title,runtime,rating,poster", got)
+	}
+
+	if movie[1].Value != "170 mins" {
+		t.Errorf("runtime = %v; want \"170 mins\"", movie[1].Value)
+	}
+
+	if movie[3].Value != nil {
+		t.Errorf("poster = %v; want nil", movie[3].Value)
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	tree, err := toTree(envelope{
+		"facets": map[string]int{"1990s": 2},
+		"movie":  map[string]any{"genres": []string{"drama"}, "poster": nil, "runtime": data.Runtime(170), "title": "Tom & Jerry"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := encodeXML(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<response>
+	<facets>
+		<entry key="1990s">2</entry>
+	</facets>
+	<movie>
+		<genres>
+			<item>drama</item>
+		</genres>
+		<poster nil="true"></poster>
+		<runtime>170 mins</runtime>
+		<title>Tom &amp; Jerry</title>
+	</movie>
+</response>
+`
+
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEncodeMsgpack(t *testing.T) {
+	tests := []struct {
+		name string
+		data any
+		want []byte
+	}{
+		{name: "nil", data: nil, want: []byte{0xc0}},
+		{name: "bools", data: []bool{true, false}, want: []byte{0x92, 0xc3, 0xc2}},
+		{name: "positive fixint", data: 127, want: []byte{0x7f}},
+		{name: "negative fixint", data: -32, want: []byte{0xe0}},
+		{name: "int8", data: -100, want: []byte{0xd0, 0x9c}},
+		{name: "int16", data: 300, want: []byte{0xd1, 0x01, 0x2c}},
+		{name: "int32", data: 70000, want: []byte{0xd2, 0x00, 0x01, 0x11, 0x70}},
+		{name: "int64", data: int64(1) << 40, want: []byte{0xd3, 0, 0, 0x01, 0, 0, 0, 0, 0}},
+		{name: "float", data: 1.5, want: []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{name: "fixstr", data: "Heat", want: []byte{0xa4, 'H', 'e', 'a', 't'}},
+		{name: "str8", data: strings.Repeat("a", 32), want: append([]byte{0xd9, 32}, strings.Repeat("a", 32)...)},
+		// The envelope's Runtime is written in its JSON form.
+		{name: "envelope", data: envelope{"runtime": data.Runtime(102)}, want: append(append([]byte{0x81, 0xa7}, "runtime"...), append([]byte{0xa8}, "102 mins"...)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := toTree(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := encodeMsgpack(tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got % x; want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		data envelope
+		want string
+		err  error
+	}{
+		{
+			// Nested objects are flattened; later rows may add columns.
+			name: "list",
+			data: envelope{"reviews": []any{
+				map[string]any{"id": 1, "user": map[string]any{"name": "Ann"}},
+				map[string]any{"id": 2, "user": map[string]any{"name": "Bob, Jr."}, "tags": []any{"a", map[string]any{"b": 1}}},
+			}},
+			want: "id,user.name,tags\n1,Ann,\n2,\"Bob, Jr.\",\"a,{\"\"b\"\":1}\"\n",
+		},
+		{
+			name: "error message",
+			data: envelope{"error": "not found"},
+			want: "error\nnot found\n",
+		},
+		{
+			name: "validation errors",
+			data: envelope{"error": map[string]string{"title": "must be provided", "year": "must be provided"}},
+			want: "field,error\ntitle,must be provided\nyear,must be provided\n",
+		},
+		{
+			name: "single object",
+			data: envelope{"movie": map[string]any{"id": 1}},
+			err:  errNotTabular,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := toTree(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := encodeCSV(tree)
+			if err != tt.err {
+				t.Fatalf("err = %v; want %v", err, tt.err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
 )
 
 // a generic helper for logging an error message
@@ -11,9 +13,9 @@ func (app *application) logError(r *http.Request, err error) {
 	app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
 }
 
-// a generic helper for sending JSON-formatted error messages to the client.
+// a generic helper for sending error messages to the client, in the format it asked for (JSON by default).
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	err := app.writeJSON(w, envelope{"error": message}, status, nil)
+	err := app.writeResponse(w, r, envelope{"error": message}, status, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -46,3 +48,60 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
+
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// When a new movie looks like existing ones; the response links to them.
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, duplicates []*data.Movie) {
+	headers := make(http.Header)
+	existing := make([]envelope, len(duplicates))
+
+	for i, movie := range duplicates {
+		url := fmt.Sprintf("/v1/movies/%d", movie.ID)
+		headers.Add("Link", fmt.Sprintf(`<%s>; rel="duplicate"`, url))
+		existing[i] = envelope{"id": movie.ID, "title": movie.Title, "year": movie.Year, "url": url}
+	}
+
+	msg := "A movie with the same title & year exists already; pass allow_duplicate=true to create it anyway."
+
+	err := app.writeResponse(w, r, envelope{"error": msg, "duplicates": existing}, http.StatusConflict, headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "Unable to update the record due to an edit conflict; please fetch it & try again."
+	app.errorResponse(w, r, http.StatusConflict, msg)
+}
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "Invalid authentication credentials."
+	app.errorResponse(w, r, http.StatusUnauthorized, msg)
+}
+
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	// Let the client know that we expect a bearer token.
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	msg := "Invalid or missing authentication token."
+	app.errorResponse(w, r, http.StatusUnauthorized, msg)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "You must be authenticated to access this resource."
+	app.errorResponse(w, r, http.StatusUnauthorized, msg)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "Your user account doesn't have the necessary permissions to access this resource."
+	app.errorResponse(w, r, http.StatusForbidden, msg)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "The requested resource is not available in any of the formats listed in the Accept header."
+	app.errorResponse(w, r, http.StatusNotAcceptable, msg)
+}
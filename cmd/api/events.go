@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An event as kept by the broker: its sequence number, name (e.g. "movie.created") & JSON payload.
+type streamEvent struct {
+	seq  uint64
+	name string
+	data []byte
+}
+
+// Fans the change events out to the event stream subscribers & keeps the most recent ones,
+// so clients which reconnect can resume where they left off.
+//
+// Event IDs have the form "<epoch>-<seq>": the epoch is the broker's start time, so IDs handed out
+// before a restart are recognized (& can't be resumed from, the buffer being in memory).
+// The events come from the outbox relay (see relay.go), so every change is seen, whichever
+// instance (or tool) made it.
+type eventBroker struct {
+	mu          sync.Mutex
+	epoch       int64
+	seq         uint64        // of the latest event
+	buffer      []streamEvent // the latest events, oldest first
+	size        int           // the buffer's capacity
+	subscribers map[chan streamEvent]struct{}
+}
+
+func newEventBroker(size int) *eventBroker {
+	return &eventBroker{
+		epoch:       time.Now().UnixMilli(),
+		size:        size,
+		subscribers: make(map[chan streamEvent]struct{}),
+	}
+}
+
+func (b *eventBroker) id(seq uint64) string {
+	return fmt.Sprintf("%d-%d", b.epoch, seq)
+}
+
+// Returns the sequence number of an ID handed out by this broker.
+func (b *eventBroker) parseID(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != strconv.FormatInt(b.epoch, 10) {
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+func (b *eventBroker) publish(name string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e := streamEvent{seq: b.seq, name: name, data: data}
+
+	if b.size > 0 {
+		if len(b.buffer) == b.size {
+			b.buffer = b.buffer[1:]
+		}
+		b.buffer = append(b.buffer, e)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// Subscribers which can't keep up are dropped rather than slowing everyone down;
+			// they reconnect & resume from the buffer.
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Registers a subscriber. Given the ID of the last event a client saw, the buffered events it
+// missed are returned too; resumable is false if some of them aren't available anymore
+// (or the ID is unknown), in which case the client must reload its state.
+// The channel is closed if the subscriber falls behind.
+func (b *eventBroker) subscribe(lastID string) (ch chan streamEvent, missed []streamEvent, resumable bool) {
+	ch = make(chan streamEvent, 64)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers[ch] = struct{}{}
+
+	if lastID == "" {
+		return ch, nil, true
+	}
+
+	seq, ok := b.parseID(lastID)
+	if !ok || seq > b.seq {
+		return ch, nil, false
+	}
+
+	// The oldest event still buffered; every event after seq must be available.
+	oldest := b.seq - uint64(len(b.buffer)) + 1
+	if seq+1 < oldest {
+		return ch, nil, false
+	}
+
+	for _, e := range b.buffer {
+		if e.seq > seq {
+			missed = append(missed, e)
+		}
+	}
+
+	return ch, missed, true
+}
+
+func (b *eventBroker) unsubscribe(ch chan streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// In the text/event-stream format; the JSON payload never contains a newline.
+func (b *eventBroker) format(e streamEvent) string {
+	return fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", b.id(e.seq), e.name, e.data)
+}
+
+// corresponding endpoint: "GET /v1/movies/events"
+// Streams the movie.created, movie.updated & movie.deleted events as Server-Sent Events, e.g.
+//
+//	curl -N localhost:4000/v1/movies/events
+//
+// Browsers' EventSource reconnects automatically, sending the Last-Event-ID header; the missed
+// events are replayed from the broker's buffer. If they're no longer available, a "stream.reset"
+// event tells the client to reload the movies instead.
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	// EventSource can't set headers itself, so the first connection may pass the ID as a parameter.
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	ch, missed, resumable := app.events.subscribe(lastID)
+	defer app.events.unsubscribe(ch)
+
+	rc := http.NewResponseController(w)
+
+	// Writes to the stream & flushes; an error means the client is gone (or stalled).
+	send := func(chunk string) error {
+		// The server's WriteTimeout would end the stream; each write gets its own deadline instead.
+		err := rc.SetWriteDeadline(time.Now().Add(app.config.sse.writeTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+
+		_, err = io.WriteString(w, chunk)
+		if err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops proxies like nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	var start strings.Builder
+
+	// How long clients wait before reconnecting (e.g. after a restart), in milliseconds.
+	fmt.Fprintf(&start, "retry: %d\n\n", app.config.sse.retry.Milliseconds())
+
+	if !resumable {
+		start.WriteString("event: stream.reset\ndata: {\"message\": \"missed events are no longer available; please reload the movies\"}\n\n")
+	}
+
+	for _, e := range missed {
+		start.WriteString(app.events.format(e))
+	}
+
+	if send(start.String()) != nil {
+		return
+	}
+
+	// Comments keep idle connections from being closed by proxies & let us notice dead clients.
+	heartbeat := time.NewTicker(app.config.sse.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				// Dropped for falling behind; the client reconnects & resumes.
+				return
+			}
+			if send(app.events.format(e)) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if send(": heartbeat\n\n") != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-app.shutdown:
+			// Ending the response lets the graceful shutdown close the connection.
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBrokerResume(t *testing.T) {
+	b := newEventBroker(3)
+	for _, name := range []string{"movie.created", "movie.updated", "movie.updated", "movie.deleted"} {
+		b.publish(name, []byte("{}"))
+	}
+
+	// Events 2 to 4 are buffered.
+	tests := []struct {
+		lastID    string
+		missed    []uint64
+		resumable bool
+	}{
+		{lastID: "", missed: nil, resumable: true},
+		{lastID: b.id(1), missed: []uint64{2, 3, 4}, resumable: true},
+		{lastID: b.id(3), missed: []uint64{4}, resumable: true},
+		{lastID: b.id(4), missed: nil, resumable: true},
+		{lastID: b.id(0), missed: nil, resumable: false}, // event 1 is gone
+		{lastID: b.id(5), missed: nil, resumable: false}, // from the future
+		{lastID: "12-3", missed: nil, resumable: false},  // from an earlier run
+		{lastID: "nonsense", missed: nil, resumable: false},
+	}
+
+	for _, tt := range tests {
+		ch, missed, resumable := b.subscribe(tt.lastID)
+		b.unsubscribe(ch)
+
+		var seqs []uint64
+		for _, e := range missed {
+			seqs = append(seqs, e.seq)
+		}
+
+		if resumable != tt.resumable || len(seqs) != len(tt.missed) {
+			t.Errorf("subscribe(%q) = %v, %t; want %v, %t", tt.lastID, seqs, resumable, tt.missed, tt.resumable)
+			continue
+		}
+		for i := range seqs {
+			if seqs[i] != tt.missed[i] {
+				t.Errorf("subscribe(%q) = %v; want %v", tt.lastID, seqs, tt.missed)
+				break
+			}
+		}
+	}
+}
+
+func TestEventBrokerDropsSlowSubscribers(t *testing.T) {
+	b := newEventBroker(10)
+
+	ch, _, _ := b.subscribe("")
+	for range cap(ch) + 1 {
+		b.publish("movie.updated", []byte("{}"))
+	}
+
+	received := 0
+	for range ch {
+		received++
+	}
+
+	if received != cap(ch) {
+		t.Errorf("received %d events before the channel was closed; want %d", received, cap(ch))
+	}
+}
+
+func TestMovieEventsHandler(t *testing.T) {
+	app := &application{events: newEventBroker(10), shutdown: make(chan struct{})}
+	app.config.sse.heartbeat = time.Hour
+	app.config.sse.retry = time.Second
+	app.config.sse.writeTimeout = time.Second
+
+	app.events.publish("movie.created", []byte(`{"n":1}`))
+	app.events.publish("movie.updated", []byte(`{"n":2}`))
+
+	srv := httptest.NewServer(app.routes())
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/movies/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Last-Event-ID", app.events.id(1))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %d %q; want 200 text/event-stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Error("the stream must not be compressed")
+	}
+
+	body := bufio.NewReader(resp.Body)
+
+	// Reads the next event (or other block of lines) of the stream.
+	next := func() string {
+		var block []string
+		for {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading the stream: %s", err)
+			}
+			if line == "\n" {
+				return strings.Join(block, "")
+			}
+			block = append(block, line)
+		}
+	}
+
+	if got := next(); got != "retry: 1000\n" {
+		t.Errorf("got %q; want the retry delay first", got)
+	}
+
+	// The missed event is replayed...
+	want := "id: " + app.events.id(2) + "\nevent: movie.updated\ndata: {\"n\":2}\n"
+	if got := next(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// ... & new ones follow live.
+	app.events.publish("movie.deleted", []byte(`{"n":3}`))
+
+	want = "id: " + app.events.id(3) + "\nevent: movie.deleted\ndata: {\"n\":3}\n"
+	if got := next(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// The shutdown ends the stream.
+	close(app.shutdown)
+
+	_, err = io.ReadAll(body)
+	if err != nil {
+		t.Errorf("the stream didn't end cleanly: %s", err)
+	}
+}
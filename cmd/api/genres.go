@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// corresponding endpoint: "GET /v1/genres"
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, envelope{"genres": genres}, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// corresponding endpoint: "POST /v1/genres"
+// e.g. curl -d '{"name": "Film Noir", "aliases": ["noir"]}' localhost:4000/v1/genres
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Slug    string   `json:"slug"`
+		Name    string   `json:"name"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	genre := &data.Genre{
+		Slug:    input.Slug,
+		Name:    input.Name,
+		Aliases: []string{},
+	}
+
+	// The slug defaults to the slugified name.
+	if genre.Slug == "" {
+		genre.Slug = data.Slugify(input.Name)
+	}
+
+	for _, alias := range input.Aliases {
+		genre.Aliases = append(genre.Aliases, data.Slugify(alias))
+	}
+
+	v := validator.New()
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Insert(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("slug", "the slug or one of the aliases is already in use by another genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, envelope{"genre": genre}, http.StatusCreated, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// corresponding endpoint: "PATCH /v1/genres/:slug"
+// Changing the slug keeps the old one as an alias & retags all the movies using it.
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	genre, ok := app.fetchGenre(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Slug *string `json:"slug"`
+		Name *string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	oldSlug := genre.Slug
+
+	if input.Slug != nil {
+		genre.Slug = *input.Slug
+	}
+
+	if input.Name != nil {
+		genre.Name = *input.Name
+	}
+
+	// The aliases are not editable here; the slug only needs to clash with other genres.
+	genre.Aliases = nil
+
+	v := validator.New()
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Rename(oldSlug, genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("slug", "is already in use by another genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Re-read the genre to report the up to date aliases & movie count.
+	genre, err = app.models.Genres.Get(genre.Slug)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, envelope{"genre": genre}, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// corresponding endpoint: "POST /v1/genres/:slug/merge"
+// e.g. curl -d '{"into": "sci-fi"}' localhost:4000/v1/genres/science-fiction-films/merge
+func (app *application) mergeGenreHandler(w http.ResponseWriter, r *http.Request) {
+	source, ok := app.fetchGenre(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Into string `json:"into"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Into != "", "into", "must be provided")
+	v.Check(input.Into != source.Slug, "into", "must be a different genre")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	target, err := app.models.Genres.Get(input.Into)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("into", "no genre exists with this slug")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Genres.Merge(source, target)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	target, err = app.models.Genres.Get(target.Slug)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, envelope{"genre": target}, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Looks up the genre from the ":slug" URL parameter.
+// If anything goes wrong, the error response has already been sent & ok is false.
+func (app *application) fetchGenre(w http.ResponseWriter, r *http.Request) (*data.Genre, bool) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	genre, err := app.models.Genres.Get(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return genre, true
+}
@@ -31,7 +31,7 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		},
 	}
 
-	err := app.writeJSON(w, info, http.StatusOK, nil)
+	err := app.writeResponse(w, r, info, http.StatusOK, nil)
 	if err != nil {
 		// app.logger.Error(err.Error())
 		// msg := "Server encountered an issue & could not process your request"
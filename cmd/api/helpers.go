@@ -1,6 +1,8 @@
 package main
 
 import (
+	"compress/flate"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/heschmat/go_movies_api_rest/internal/data"
 	"github.com/heschmat/go_movies_api_rest/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -29,6 +32,18 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// Like readIDParam, but for routes which carry more than one id (e.g. "/v1/movies/:id/credits/:credit_id").
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+
+	return id, nil
+}
+
 // w: the destination http.ResponseWriter
 // data: data to encode to JSON
 // status: the HTTP status code to send
@@ -64,6 +79,30 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
+	// Bulk uploads may be gzip-compressed ("Content-Encoding: gzip").
+	// The decompressed body is limited as well, so a small "zip bomb" can't expand without bounds.
+	compressed := false
+
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		compressed = true
+
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("body must not be empty")
+			}
+			return errors.New("body contains invalid gzip data")
+		}
+		defer gz.Close()
+
+		maxBytes *= 10
+		r.Body = http.MaxBytesReader(w, io.NopCloser(gz), int64(maxBytes))
+	default:
+		return fmt.Errorf("unsupported Content-Encoding %q (must be gzip)", r.Header.Get("Content-Encoding"))
+	}
+
 	// Initialize the json.Decoder.
 	dec := json.NewDecoder(r.Body)
 	// Make sure if the JSON sent by client does NOT include unkown fields.
@@ -83,11 +122,15 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		var InvalidUnmarshalErr *json.InvalidUnmarshalError
 
 		var maxBytesErr			*http.MaxBytesError
+		var flateErr			flate.CorruptInputError
 
 		switch {
 		case errors.As(err, &maxBytesErr):
 			return fmt.Errorf("body must not be larger than %d bytes", maxBytesErr.Limit)
 
+		case errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum), errors.As(err, &flateErr):
+			return errors.New("body contains invalid gzip data")
+
 		// curl -d '{"title": "creed I",}' localhost:4000/v1/movie
 		case errors.As(err, &syntaxErr):
 			msg := "body contains badly-formed JSON (at character %d)"
@@ -128,6 +171,11 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	// Otherwise, there's additional data in the request body, which we don't desire.
 	err = dec.Decode(&struct{}{})
 	if !errors.Is(err, io.EOF) {
+		// The gzip trailer (& its checksum) is only read at the end of the body.
+		var flateErr flate.CorruptInputError
+		if errors.Is(err, gzip.ErrChecksum) || errors.As(err, &flateErr) || (compressed && errors.Is(err, io.ErrUnexpectedEOF)) {
+			return errors.New("body contains invalid gzip data")
+		}
 		return errors.New("body must only contain a single JSON value")
 	}
 
@@ -174,3 +222,37 @@ func (app *application) readInt(qs url.Values, key string, defaultVal int, v *va
 
 	return i
 }
+
+// Accepts "true", "false", "1", "0" ... (anything strconv.ParseBool understands).
+func (app *application) readBool(qs url.Values, key string, defaultVal bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultVal
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultVal
+	}
+
+	return b
+}
+
+// Accepts the runtime in the same form the API emits ("102 mins") or as plain minutes ("102").
+func (app *application) readRuntime(qs url.Values, key string, defaultVal data.Runtime, v *validator.Validator) data.Runtime {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultVal
+	}
+
+	runtime, err := data.ParseRuntime(s)
+	if err != nil {
+		v.AddError(key, `must be a number of minutes, e.g. "102" or "102 mins"`)
+		return defaultVal
+	}
+
+	return runtime
+}
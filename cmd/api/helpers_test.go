@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadJSONGzip(t *testing.T) {
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(s))
+		gz.Close()
+		return buf.Bytes()
+	}
+
+	valid := gzipped(`{"title": "Heat"}`)
+
+	// A valid gzip header followed by a corrupt deflate stream.
+	corrupt := append(append([]byte{}, valid[:10]...), 0xff, 0xff, 0xff, 0xff)
+
+	// The CRC-32 in the trailer doesn't match the content.
+	badChecksum := append([]byte{}, valid...)
+	badChecksum[len(badChecksum)-8] ^= 0xff
+
+	// Well under the 1MB limit compressed, but expanding way beyond 10MB.
+	bomb := gzipped(`{"title": "` + strings.Repeat("a", 11_000_000) + `"}`)
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		title    string
+		err      string
+	}{
+		{name: "gzip", encoding: "gzip", body: valid, title: "Heat"},
+		{name: "x-gzip", encoding: "X-Gzip", body: valid, title: "Heat"},
+		{name: "identity", encoding: "identity", body: []byte(`{"title": "Heat"}`), title: "Heat"},
+		{name: "not gzip", encoding: "gzip", body: []byte(`{"title": "Heat"}`), err: "body contains invalid gzip data"},
+		{name: "corrupt", encoding: "gzip", body: corrupt, err: "body contains invalid gzip data"},
+		{name: "truncated", encoding: "gzip", body: valid[:len(valid)-4], err: "body contains invalid gzip data"},
+		{name: "bad checksum", encoding: "gzip", body: badChecksum, err: "body contains invalid gzip data"},
+		{name: "empty", encoding: "gzip", body: nil, err: "body must not be empty"},
+		{name: "too large", encoding: "gzip", body: bomb, err: "body must not be larger than 10485760 bytes"},
+		{name: "unsupported", encoding: "br", body: valid, err: `unsupported Content-Encoding "br" (must be gzip)`},
+	}
+
+	app := &application{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/movies", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", tt.encoding)
+
+			var input struct {
+				Title string `json:"title"`
+			}
+
+			err := app.readJSON(httptest.NewRecorder(), r, &input)
+
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("err = %v; want %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if input.Title != tt.title {
+				t.Errorf("title = %q; want %q", input.Title, tt.title)
+			}
+		})
+	}
+}
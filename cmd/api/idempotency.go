@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+)
+
+// Captures the response passing through to the client, so it can be stored for replays.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *idempotencyRecorder) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Where the idempotency keys & the stored responses are kept; data.IdempotencyModel in production.
+type idempotencyStore interface {
+	Claim(userID int64, key string, fingerprint []byte, ttl, lockTimeout time.Duration) (*data.IdempotencyRecord, bool, error)
+	Complete(rec *data.IdempotencyRecord) error
+	Release(userID int64, key string) error
+}
+
+// Makes a POST handler safe to retry: a request sent with an "Idempotency-Key" header (e.g. a UUID)
+// is processed once, & retries with the same key get the stored response back, marked with
+// "Idempotent-Replayed: true". Keys are scoped per user & kept for the configured window.
+//
+// Reusing a key for a different request (method, path or body) is a 422; a retry arriving while the
+// first request is still being processed is a 409. Server errors aren't stored, so those can be retried.
+//
+// Anonymous clients can't be told apart, so two of them may well pick the same key. Their keys are
+// scoped by the request too: only a retry of the very same request is replayed (or gets the 409),
+// while a different request with the same key is simply processed on its own.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		user := app.contextGetUser(r)
+
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key must not be more than 255 bytes long"))
+			return
+		}
+
+		// The body is read up front for the fingerprint, & handed on to the handler afterwards.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.New()
+		fmt.Fprintf(fingerprint, "%s %s\n", r.Method, r.URL.RequestURI())
+		fingerprint.Write(body)
+
+		if user.IsAnonymous() {
+			key = fmt.Sprintf("%s %x", key, fingerprint.Sum(nil))
+		}
+
+		cfg := app.config.idempotency
+
+		rec, claimed, err := app.idempotencyKeys.Claim(user.ID, key, fingerprint.Sum(nil), cfg.window, cfg.lockTimeout)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !claimed {
+			switch {
+			case !rec.Matches(fingerprint.Sum(nil)):
+				msg := "This Idempotency-Key was already used for a different request; please use a new key."
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, msg)
+			case rec.StatusCode == 0:
+				w.Header().Set("Retry-After", "1")
+				app.conflictResponse(w, r, "A request with this Idempotency-Key is still being processed; please retry later.")
+			default:
+				for name, values := range rec.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(rec.StatusCode)
+				w.Write(rec.Body)
+			}
+			return
+		}
+
+		rw := &idempotencyRecorder{ResponseWriter: w}
+		completed := false
+
+		// Unless the response is stored, the key is released, e.g. if the handler panics.
+		defer func() {
+			if completed {
+				return
+			}
+
+			err := app.idempotencyKeys.Release(user.ID, key)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(rw, r)
+
+		if rw.status >= 500 {
+			return
+		}
+
+		// These are set by the outer middleware (e.g. the compression) for this particular response;
+		// the stored body is the uncompressed one.
+		header := w.Header().Clone()
+		for _, name := range []string{"Content-Encoding", "Content-Length", "Vary"} {
+			header.Del(name)
+		}
+
+		rec = &data.IdempotencyRecord{
+			UserID:     user.ID,
+			Key:        key,
+			StatusCode: rw.status,
+			Header:     header,
+			Body:       rw.body.Bytes(),
+		}
+
+		err = app.idempotencyKeys.Complete(rec)
+		if err != nil {
+			// The client has its response already; a retry will be processed again though.
+			app.logError(r, err)
+			return
+		}
+
+		completed = true
+	})
+}
+
+// Deletes the expired idempotency keys every hour, until the shutdown.
+func (app *application) pruneIdempotencyKeys() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			deleted, err := app.models.Idempotency.DeleteExpired()
+			if err != nil {
+				app.logger.Error(err.Error())
+				continue
+			}
+
+			if deleted > 0 {
+				app.logger.Info("pruned the idempotency keys", "keys", deleted)
+			}
+		case <-app.shutdown:
+			return
+		}
+	}
+}
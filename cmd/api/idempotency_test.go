@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+)
+
+func TestIdempotent(t *testing.T) {
+	alice := &data.User{ID: 1}
+	bob := &data.User{ID: 2}
+
+	// A request to send: who sends it, with which key & body.
+	type request struct {
+		user *data.User
+		key  string
+		body string
+	}
+
+	// The expected response.
+	type response struct {
+		status   int
+		replayed bool
+		body     string // a fragment of it
+	}
+
+	tests := []struct {
+		name     string
+		requests []request
+		want     []response
+		calls    int // how often the handler should run
+		status   int // what the handler responds with
+	}{
+		{
+			name:     "replay",
+			requests: []request{{alice, "k1", `{"title": "Heat"}`}, {alice, "k1", `{"title": "Heat"}`}},
+			want:     []response{{201, false, `"call": 1`}, {201, true, `"call": 1`}},
+			calls:    1,
+		},
+		{
+			name:     "different body",
+			requests: []request{{alice, "k1", `{"title": "Heat"}`}, {alice, "k1", `{"title": "Ronin"}`}},
+			want:     []response{{201, false, `"call": 1`}, {422, false, "already used for a different request"}},
+			calls:    1,
+		},
+		{
+			name:     "other key",
+			requests: []request{{alice, "k1", `{"title": "Heat"}`}, {alice, "k2", `{"title": "Heat"}`}},
+			want:     []response{{201, false, `"call": 1`}, {201, false, `"call": 2`}},
+			calls:    2,
+		},
+		{
+			name:     "no key",
+			requests: []request{{alice, "", `{"title": "Heat"}`}, {alice, "", `{"title": "Heat"}`}},
+			want:     []response{{201, false, `"call": 1`}, {201, false, `"call": 2`}},
+			calls:    2,
+		},
+		{
+			name:     "scoped per user",
+			requests: []request{{alice, "k1", `{"title": "Heat"}`}, {bob, "k1", `{"title": "Heat"}`}},
+			want:     []response{{201, false, `"call": 1`}, {201, false, `"call": 2`}},
+			calls:    2,
+		},
+		{
+			name:     "anonymous replay",
+			requests: []request{{data.AnonymousUser, "k1", `{"email": "a@example.com"}`}, {data.AnonymousUser, "k1", `{"email": "a@example.com"}`}},
+			want:     []response{{201, false, `"call": 1`}, {201, true, `"call": 1`}},
+			calls:    1,
+		},
+		{
+			// Another anonymous client may have picked the same key; that's not a mistake to report.
+			name:     "anonymous different body",
+			requests: []request{{data.AnonymousUser, "k1", `{"email": "a@example.com"}`}, {data.AnonymousUser, "k1", `{"email": "b@example.com"}`}},
+			want:     []response{{201, false, `"call": 1`}, {201, false, `"call": 2`}},
+			calls:    2,
+		},
+		{
+			// Server errors aren't stored, so the retry is processed again.
+			name:     "server error",
+			requests: []request{{alice, "k1", `{"title": "Heat"}`}, {alice, "k1", `{"title": "Heat"}`}},
+			want:     []response{{500, false, `"call": 1`}, {500, false, `"call": 2`}},
+			calls:    2,
+			status:   http.StatusInternalServerError,
+		},
+		{
+			name:     "client error",
+			requests: []request{{alice, "k1", `{"title": ""}`}, {alice, "k1", `{"title": ""}`}},
+			want:     []response{{422, false, `"call": 1`}, {422, true, `"call": 1`}},
+			calls:    1,
+			status:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "key too long",
+			requests: []request{{alice, strings.Repeat("k", 256), `{"title": "Heat"}`}},
+			want:     []response{{400, false, "must not be more than 255 bytes long"}},
+			calls:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &stubIdempotencyStore{records: make(map[string]*data.IdempotencyRecord)}
+
+			app := &application{
+				logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+				idempotencyKeys: store,
+			}
+			app.config.idempotency.window = time.Hour
+			app.config.idempotency.lockTimeout = time.Minute
+
+			status := tt.status
+			if status == 0 {
+				status = http.StatusCreated
+			}
+
+			calls := 0
+			handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				app.writeJSON(w, envelope{"call": calls}, status, nil)
+			})
+
+			for i, req := range tt.requests {
+				rr := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(req.body))
+				if req.key != "" {
+					r.Header.Set("Idempotency-Key", req.key)
+				}
+				r = app.contextSetUser(r, req.user)
+
+				handler(rr, r)
+
+				want := tt.want[i]
+
+				if rr.Code != want.status {
+					t.Errorf("request %d: status = %d; want %d (%s)", i+1, rr.Code, want.status, rr.Body)
+				}
+
+				if replayed := rr.Header().Get("Idempotent-Replayed") == "true"; replayed != want.replayed {
+					t.Errorf("request %d: replayed = %t; want %t", i+1, replayed, want.replayed)
+				}
+
+				if !strings.Contains(rr.Body.String(), want.body) {
+					t.Errorf("request %d: body = %s; want it to contain %s", i+1, rr.Body, want.body)
+				}
+			}
+
+			if calls != tt.calls {
+				t.Errorf("the handler ran %d times; want %d", calls, tt.calls)
+			}
+
+			// Nothing stays claimed without a response.
+			for key, rec := range store.records {
+				if rec.StatusCode == 0 {
+					t.Errorf("key %q is still claimed", key)
+				}
+			}
+		})
+	}
+}
+
+// A retry arriving while the first request is still being processed gets a 409.
+func TestIdempotentInFlight(t *testing.T) {
+	app := &application{
+		logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		idempotencyKeys: &stubIdempotencyStore{records: make(map[string]*data.IdempotencyRecord)},
+	}
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(`{"title": "Heat"}`))
+		r.Header.Set("Idempotency-Key", "k1")
+		return app.contextSetUser(r, &data.User{ID: 1})
+	}
+
+	var retry *httptest.ResponseRecorder
+	var handler http.HandlerFunc
+
+	handler = app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		// The client retries before this request completes.
+		if retry == nil {
+			retry = httptest.NewRecorder()
+			handler(retry, newRequest())
+		}
+
+		app.writeJSON(w, envelope{"movie": "created"}, http.StatusCreated, nil)
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, newRequest())
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("first request: status = %d; want %d", rr.Code, http.StatusCreated)
+	}
+
+	if retry.Code != http.StatusConflict {
+		t.Errorf("retry: status = %d; want %d (%s)", retry.Code, http.StatusConflict, retry.Body)
+	}
+
+	if got := retry.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("retry: Retry-After = %q; want 1", got)
+	}
+}
+
+// Keeps the records in memory, following data.IdempotencyModel (without expiry).
+type stubIdempotencyStore struct {
+	records map[string]*data.IdempotencyRecord
+}
+
+func (s *stubIdempotencyStore) Claim(userID int64, key string, fingerprint []byte, ttl, lockTimeout time.Duration) (*data.IdempotencyRecord, bool, error) {
+	id := fmt.Sprintf("%d/%s", userID, key)
+
+	if rec, ok := s.records[id]; ok {
+		copied := *rec
+		return &copied, false, nil
+	}
+
+	s.records[id] = &data.IdempotencyRecord{UserID: userID, Key: key, Fingerprint: fingerprint}
+	return nil, true, nil
+}
+
+func (s *stubIdempotencyStore) Complete(rec *data.IdempotencyRecord) error {
+	if existing, ok := s.records[fmt.Sprintf("%d/%s", rec.UserID, rec.Key)]; ok && existing.StatusCode == 0 {
+		existing.StatusCode, existing.Header, existing.Body = rec.StatusCode, rec.Header, rec.Body
+	}
+	return nil
+}
+
+func (s *stubIdempotencyStore) Release(userID int64, key string) error {
+	id := fmt.Sprintf("%d/%s", userID, key)
+
+	if rec, ok := s.records[id]; ok && rec.StatusCode == 0 {
+		delete(s.records, id)
+	}
+	return nil
+}
@@ -2,16 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	// Import the pq driver so that it can register itself with *database/sql* package.
 	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/internal/storage"
 	_ "github.com/lib/pq"
 )
 
@@ -34,13 +38,60 @@ type config struct {
 		maxIdleConns	int
 		maxIdleTime		time.Duration 	//300ms, 4s, 5h27m
 	}
+	search struct {
+		fuzzyThreshold	float64		// minimum trigram similarity (0-1) for "match=fuzzy" title searches
+		duplicateThreshold	float64	// minimum title similarity (0-1) of likely duplicate movies; 0 only compares normalized titles
+	}
+	cursor struct {
+		key				[]byte		// HMAC key for signing pagination cursors
+	}
+	cache struct {
+		enabled			bool			// whether movie lookups are cached in-process
+		size			int				// maximum number of cached movies
+		ttl				time.Duration	// how long a cached movie may be served
+	}
+	webhooks struct {
+		timeout			time.Duration	// per delivery attempt
+		maxAttempts		int				// per event, including the first attempt
+		retryDelay		time.Duration	// before the first retry; doubles on every further one (see webhookRetryDelay)
+		disableAfter	int				// failed events in a row before a webhook is disabled
+	}
+	sse struct {
+		bufferSize		int				// events kept for clients resuming with Last-Event-ID
+		heartbeat		time.Duration	// interval of the keep-alive comments
+		retry			time.Duration	// how long clients wait before reconnecting
+		writeTimeout	time.Duration	// per write; stalled clients are disconnected
+	}
+	idempotency struct {
+		window			time.Duration	// how long the responses are kept for replays
+		lockTimeout		time.Duration	// after which a request still in flight is considered abandoned
+	}
+	outbox struct {
+		pollInterval	time.Duration	// how often the relay checks for events it wasn't notified of
+		retention		time.Duration	// how long the relayed events are kept
+	}
+	posters struct {
+		dir				string			// where the local storage keeps the uploaded posters
+		maxSize			int64			// of an uploaded poster, in bytes
+		maxDimension	int				// the largest width & height (in pixels) of an uploaded poster
+	}
 }
 
 // The *application* struct holds all the `dependencies` for the HTTP handlers, helpers & middleware.
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
+	config        config
+	logger        *slog.Logger
+	models        data.Models
+	webhookClient *http.Client
+	events        *eventBroker
+	posters       storage.Storage
+	// The Idempotency-Key records (see idempotent()); models.Idempotency outside of the tests.
+	idempotencyKeys idempotencyStore
+	// Signalled when webhook deliveries are queued, so they're attempted without waiting for the poll.
+	webhookWake chan struct{}
+	// Closed when the graceful shutdown starts, so long-running work (streams, retries) can stop.
+	shutdown chan struct{}
+	wg       sync.WaitGroup // the goroutines started by background()
 }
 
 
@@ -59,14 +110,103 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15 * time.Minute, "PostgreSQL max connection idle time")
+
+	// 0.3 is also the pg_trgm default.
+	flag.Float64Var(&cfg.search.fuzzyThreshold, "search-fuzzy-threshold", 0.3, "Minimum title similarity (0-1) for fuzzy searches")
+	flag.Float64Var(&cfg.search.duplicateThreshold, "duplicate-threshold", 0.8, "Minimum title similarity (0-1) of likely duplicate movies of the same year; 0 only matches equal titles")
+	// Cursors stay valid across restarts (& instances) only if they share the secret.
+	cursorSecret := flag.String("cursor-secret", os.Getenv("MOVIES_CURSOR_SECRET"), "Secret for signing pagination cursors")
+
+	// Read the movie cache settings.
+	flag.BoolVar(&cfg.cache.enabled, "cache-enabled", true, "Enable the in-process movie cache")
+	flag.IntVar(&cfg.cache.size, "cache-size", 1000, "Maximum number of movies in the cache")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "How long a cached movie may be served")
+
+	// Read the webhook delivery settings.
+	flag.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", 10*time.Second, "Timeout of each webhook delivery attempt")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", 5, "Delivery attempts per webhook event")
+	flag.DurationVar(&cfg.webhooks.retryDelay, "webhook-retry-delay", 10*time.Second, "Delay before the first webhook retry (doubles on every further one)")
+	flag.IntVar(&cfg.webhooks.disableAfter, "webhook-disable-after", 10, "Failed events in a row before a webhook is disabled")
+
+	// Read the event stream settings.
+	flag.IntVar(&cfg.sse.bufferSize, "sse-buffer-size", 1000, "Events kept for event stream clients resuming with Last-Event-ID")
+	flag.DurationVar(&cfg.sse.heartbeat, "sse-heartbeat", 15*time.Second, "Interval of the event stream heartbeats")
+	cfg.sse.retry = 3 * time.Second
+	cfg.sse.writeTimeout = 10 * time.Second
+
+	// Read the idempotency settings.
+	flag.DurationVar(&cfg.idempotency.window, "idempotency-window", 24*time.Hour, "How long responses to requests with an Idempotency-Key are kept for replays")
+	flag.DurationVar(&cfg.idempotency.lockTimeout, "idempotency-lock-timeout", time.Minute, "How long a request with an Idempotency-Key may take before a retry may take it over")
+
+	// Read the outbox relay settings.
+	flag.DurationVar(&cfg.outbox.pollInterval, "outbox-poll-interval", 5*time.Second, "How often the outbox relay checks for events it wasn't notified of")
+	flag.DurationVar(&cfg.outbox.retention, "outbox-retention", 7*24*time.Hour, "How long the relayed change events are kept")
+
+	// Read the poster upload settings.
+	flag.StringVar(&cfg.posters.dir, "poster-dir", "./uploads/posters", "Directory the uploaded posters are stored in")
+	flag.Int64Var(&cfg.posters.maxSize, "poster-max-size", 10<<20, "Maximum size of an uploaded poster, in bytes")
+	flag.IntVar(&cfg.posters.maxDimension, "poster-max-dimension", 4000, "Maximum width & height of an uploaded poster, in pixels")
 	flag.Parse()
 
+	if cfg.search.fuzzyThreshold < 0 || cfg.search.fuzzyThreshold > 1 {
+		fmt.Fprintln(os.Stderr, "search-fuzzy-threshold must be between 0 and 1")
+		os.Exit(2)
+	}
+
+	if cfg.search.duplicateThreshold < 0 || cfg.search.duplicateThreshold > 1 {
+		fmt.Fprintln(os.Stderr, "duplicate-threshold must be between 0 and 1")
+		os.Exit(2)
+	}
+
+	if cfg.webhooks.maxAttempts < 1 || cfg.webhooks.disableAfter < 1 {
+		fmt.Fprintln(os.Stderr, "webhook-max-attempts & webhook-disable-after must be at least 1")
+		os.Exit(2)
+	}
+
+	// The retry delay doubles with every attempt (up to maxWebhookRetryDelay); past this many
+	// attempts, a delivery would be retried for days.
+	if cfg.webhooks.maxAttempts > maxWebhookAttempts || cfg.webhooks.retryDelay <= 0 {
+		fmt.Fprintf(os.Stderr, "webhook-max-attempts must not be more than %d & webhook-retry-delay must be positive\n", maxWebhookAttempts)
+		os.Exit(2)
+	}
+
+	if cfg.sse.bufferSize < 0 || cfg.sse.heartbeat <= 0 {
+		fmt.Fprintln(os.Stderr, "sse-buffer-size must not be negative & sse-heartbeat must be positive")
+		os.Exit(2)
+	}
+
+	if cfg.idempotency.window <= 0 || cfg.idempotency.lockTimeout <= 0 {
+		fmt.Fprintln(os.Stderr, "idempotency-window & idempotency-lock-timeout must be positive")
+		os.Exit(2)
+	}
+
+	if cfg.outbox.pollInterval <= 0 || cfg.outbox.retention <= 0 {
+		fmt.Fprintln(os.Stderr, "outbox-poll-interval & outbox-retention must be positive")
+		os.Exit(2)
+	}
+
+	if cfg.posters.maxSize < 1 || cfg.posters.maxDimension < 1 {
+		fmt.Fprintln(os.Stderr, "poster-max-size & poster-max-dimension must be positive")
+		os.Exit(2)
+	}
+
 	// Inisitalize a new structured logger --------------------- //
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		AddSource: true,  		// the filename & line number of the calling source code
 		Level: slog.LevelDebug,	// the minimum log level
 	}))
 
+	cfg.cursor.key = []byte(*cursorSecret)
+	if *cursorSecret == "" {
+		cfg.cursor.key = make([]byte, 32)
+		_, err := rand.Read(cfg.cursor.key)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		logger.Warn("no cursor secret configured; pagination cursors will not survive a restart")
+	}
+
 	// Create the connection pool ------------------------------ //
 	db, err := openDB(cfg)
 	if err != nil {
@@ -79,28 +219,54 @@ func main() {
 
 	logger.Info("database connection pool established")
 
+	posters, err := storage.NewLocal(cfg.posters.dir)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	// A nil cache disables caching.
+	var movieCache *data.MovieCache
+	if cfg.cache.enabled {
+		movieCache = data.NewMovieCache(cfg.cache.size, cfg.cache.ttl)
+	}
+
+	// Publish the application metrics; they're served at "GET /debug/vars".
+	expvar.NewString("version").Set(version)
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+	expvar.Publish("movie_cache", expvar.Func(func() any {
+		return movieCache.Stats()
+	}))
+
 	// Declare an instance of the application struct.
+	// Initialize a Models struct; passing in the connection pool & the movie cache as parameters.
+	models := data.NewModels(db, movieCache)
+
 	app := &application{
 		config: cfg,
 		logger: logger,
-		// Initialize a Models struct; passing in the connection pool as a parameter.
-		models: data.NewModels(db),
+		models: models,
+		idempotencyKeys: models.Idempotency,
+		webhookClient: newWebhookClient(cfg.webhooks.timeout),
+		events: newEventBroker(cfg.sse.bufferSize),
+		posters: posters,
+		webhookWake: make(chan struct{}, 1),
+		shutdown: make(chan struct{}),
 	}
 
-	srv := &http.Server{
-		Addr: fmt.Sprintf(":%d", cfg.port),
-		Handler: app.routes(),
-		IdleTimeout: time.Minute,
-		ReadTimeout: 5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
-	}
+	// Relay the change events to the webhooks & the event stream until the shutdown.
+	app.background(app.runOutboxRelay)
+	app.background(app.runWebhookDeliveries)
+	app.background(app.pruneIdempotencyKeys)
 
-	// Start the HTTP server.
-	logger.Info("Starting server", "addr", srv.Addr, "env", cfg.env)
-	err = srv.ListenAndServe()
-	logger.Error(err.Error())
-	os.Exit(1)
+	// Start the HTTP server; it returns once a graceful shutdown is complete.
+	err = app.serve()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 }
 
 
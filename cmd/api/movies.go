@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"github.com/heschmat/go_movies_api_rest/internal/data"
 	"github.com/heschmat/go_movies_api_rest/internal/validator"
@@ -29,6 +31,9 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Initialize a new validator instance.
 	v := validator.New()
 
+	// Likely duplicates of existing movies are refused, unless the client insists.
+	allowDuplicate := app.readBool(r.URL.Query(), "allow_duplicate", false, v)
+
 	// Copy the vaulues from the input struct into a new *Movie* struct.
 	movie := &data.Movie{
 		Title: 		input.Title,
@@ -36,13 +41,37 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Runtime: 	data.Runtime(input.Runtime),
 		Genres: 	input.Genres,
 	}
+
+	// Genres are stored as canonical slugs, e.g. "Sci-Fi" => "sci-fi".
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movie.Genres = taxonomy.Normalize(movie.Genres)
+
 	// If any of the checks failed, send `422 unprocessable entity` error.
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, taxonomy); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	store := app.movieStore(r)
+
+	if !allowDuplicate {
+		duplicates, err := store.FindDuplicates(movie, app.config.search.duplicateThreshold)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(duplicates) > 0 {
+			app.duplicateMovieResponse(w, r, duplicates)
+			return
+		}
+	}
+
+	err = store.Insert(movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -52,7 +81,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Let the client know which URL the newly-created resource can be found at.
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
-	err = app.writeJSON(w, envelope{"movie": movie}, http.StatusCreated, headers)
+	err = app.writeResponse(w, r, envelope{"movie": movie}, http.StatusCreated, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -67,7 +96,21 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	// A sparse fieldset, e.g. "?fields=id,title,year"; by default all fields are returned.
+	fields := app.readCSVString(r.URL.Query(), "fields", nil)
+
+	v := validator.New()
+	data.ValidateMovieFields(v, fields)
+
+	// The title is returned in the requested language, if the movie has a translation into it.
+	locales := app.readLocales(r, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -79,8 +122,28 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if fields == nil || slices.Contains(fields, "title") {
+		err = app.models.Translations.Localize([]*data.Movie{movie}, locales)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+
+	headers := make(http.Header)
+	if movie.TitleLocale != "" {
+		headers.Set("Content-Language", movie.TitleLocale)
+	}
+
 	// Pass an *envelop map* instead of passing the plain movie struct.
-	err = app.writeJSON(w, envelope{"movie": movie}, http.StatusOK, nil)
+	resp := envelope{"movie": movie}
+	if fields != nil {
+		resp["movie"] = movie.Project(fields)
+	}
+
+	err = app.writeResponse(w, r, resp, http.StatusOK, headers)
 	if err != nil {
 		// app.logger.Error(err.Error())
 		// msg := "Server encountered an issue & could not process your request"
@@ -96,18 +159,30 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	// As for updates, e.g. "X-Expected-Version: 3" only deletes the movie while it's at version 3.
+	var version int64
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" {
+		version, err = strconv.ParseInt(expected, 10, 32)
+		if err != nil || version < 1 {
+			app.badRequestResponse(w, r, errors.New("X-Expected-Version must be a positive integer"))
+			return
+		}
+	}
+
+	err = app.movieStore(r).DeleteVersion(id, int32(version))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, envelope{"message": "movie successfully deleted"}, http.StatusOK, nil)
+	err = app.writeResponse(w, r, envelope{"message": "movie successfully deleted"}, http.StatusOK, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -120,7 +195,9 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	store := app.movieStore(r)
+
+	movie, err := store.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -131,6 +208,15 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Clients may send the version they based their changes on, e.g. "X-Expected-Version: 3";
+	// if the movie moved on since, the update is rejected rather than silently overwriting.
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" {
+		if expected != strconv.FormatInt(int64(movie.Version), 10) {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
 	// Hold the expected data from the client.
 	var input struct {
 		Title	*string		`json:"title"`
@@ -155,27 +241,39 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	if input.Runtime != nil {
-		movie.Runtime = *&movie.Runtime
+		movie.Runtime = *input.Runtime
 	}
 
 	if input.Genres != nil {
 		movie.Genres = input.Genres
 	}
 
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movie.Genres = taxonomy.Normalize(movie.Genres)
+
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, taxonomy); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = store.Update(movie)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, envelope{"movie": movie}, http.StatusOK, nil)
+	err = app.writeResponse(w, r, envelope{"movie": movie}, http.StatusOK, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -187,9 +285,16 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	var input struct {
 		Title		string
 		Genres		[]string
-		Page		int
-		PageSize	int
-		Sort		string
+		AnyGenre	bool
+		PersonID	int
+		YearMin		int
+		YearMax		int
+		RuntimeMin	data.Runtime
+		RuntimeMax	data.Runtime
+		Match		string
+		Facets		[]string
+		Fields		[]string
+		data.Filters
 	}
 
 	v := validator.New()
@@ -198,25 +303,254 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSVString(qs, "genres", []string{})
+	// By default movies must have all the genres; "any_genre=true" matches movies having any of them.
+	input.AnyGenre = app.readBool(qs, "any_genre", false, v)
+	// Only list the movies this person is credited on.
+	input.PersonID = app.readInt(qs, "person", 0, v)
+	v.Check(input.PersonID >= 0, "person", "must be a positive integer")
+
+	// Inclusive ranges, e.g. "year_min=1990&year_max=1999&runtime_max=120 mins".
+	input.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.RuntimeMin = app.readRuntime(qs, "runtime_min", 0, v)
+	input.RuntimeMax = app.readRuntime(qs, "runtime_max", 0, v)
+
+	// "fulltext" (default) or "fuzzy"; the latter tolerates typos in the title, e.g. "Godfahter".
+	input.Match = app.readString(qs, "match", "fulltext")
+	v.Check(validator.PermittedValue(input.Match, "fulltext", "fuzzy"), "match", "must be either fulltext or fuzzy")
+	v.Check(input.Match != "fuzzy" || input.Title != "", "match", "fuzzy matching requires a title")
+
+	// e.g. "facets=genres,decade" adds the counts per genre & decade of ALL matching movies.
+	input.Facets = app.readCSVString(qs, "facets", []string{})
+	for _, facet := range input.Facets {
+		v.Check(validator.PermittedValue(facet, data.FacetSafelist...), "facets", "must only contain genres, decade or runtime_bucket")
+	}
+
+	// A sparse fieldset, e.g. "fields=id,title"; by default all fields are returned.
+	input.Fields = app.readCSVString(qs, "fields", nil)
+	data.ValidateMovieFields(v, input.Fields)
+
+	// The titles are returned in the requested language, where translated; "lang" or Accept-Language.
+	// A title search matches the translated titles too, whatever the language.
+	locales := app.readLocales(r, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 10, v)
+
+	// By default the sort is ascending by id; fuzzy matches are ordered by similarity though.
+	defaultSort := "id"
+	if input.Match == "fuzzy" {
+		defaultSort = "relevance"
+	}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "relevance", "-id", "-title", "-year", "-runtime"}
+
+	// Keyset pagination for deep scrolling: pass the next_cursor/prev_cursor from the metadata.
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorKey = app.config.cursor.key
+
+	data.ValidateFilters(v, input.Filters)
+	// Ranking needs something to rank against.
+	v.Check(input.Filters.Sort != "relevance" || input.Title != "", "sort", "relevance is only available together with a title search")
+
+	search := data.MovieSearch{
+		Title:          input.Title,
+		Genres:         input.Genres,
+		AnyGenre:       input.AnyGenre,
+		PersonID:       int64(input.PersonID),
+		YearMin:        input.YearMin,
+		YearMax:        input.YearMax,
+		RuntimeMin:     input.RuntimeMin,
+		RuntimeMax:     input.RuntimeMax,
+		Fuzzy:          input.Match == "fuzzy",
+		FuzzyThreshold: app.config.search.fuzzyThreshold,
+		Fields:         input.Fields,
+	}
+
+	if data.ValidateMovieSearch(v, search); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// A cursor which fails the signature check (or was issued for another sort) is a bad request.
+	err := input.Filters.ParseCursor()
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Resolve the genre filter to canonical slugs, so "Sci-Fi" & "science fiction" match "sci-fi".
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	search.Genres = taxonomy.Normalize(search.Genres)
+
+	movies, metadata, err := app.models.Movies.GetMovies(search, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if input.Fields == nil || slices.Contains(input.Fields, "title") {
+		err = app.models.Translations.Localize(movies, locales)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
 
-	input.Page = app.readInt(qs, "page", 1, v)
-	input.PageSize = app.readInt(qs, "page_size", 10, v)
+	resp := envelope{"movies": movies, "metadata": metadata}
 
-	// By default the sort is ascending by id.
-	input.Sort = app.readString(qs, "sort", "id")
+	if input.Fields != nil {
+		projected := make([]map[string]any, len(movies))
+		for i, movie := range movies {
+			projected[i] = movie.Project(input.Fields)
+		}
+		resp["movies"] = projected
+	}
+
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Movies.GetFacets(search, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		resp["facets"] = facets
+	}
+
+	err = app.writeResponse(w, r, resp, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// corresponding endpoint: "POST /v1/movies/:id/revert"
+// The historical state is re-applied as a brand new version; history itself is never rewritten.
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Version int32 `json:"version"`
+	}
 
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Version >= 1, "version", "must be provided")
+	v.Check(input.Version < movie.Version, "version", "must be an earlier version than the current one")
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, err := app.models.Movies.GetMovies(input.Title, input.Genres)
+	target, err := app.models.Movies.GetVersion(id, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("version", "no history recorded for this version")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Keep a copy of the current state, so we can report what the revert changed.
+	before := *movie
+
+	movie.Title = target.Title
+	movie.Year = target.Year
+	movie.Runtime = target.Runtime
+
+	// Genres may have been renamed or merged since; their old slugs resolve through the aliases.
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movie.Genres = taxonomy.Normalize(target.Genres)
+
+	// The old values must still satisfy today's rules (e.g. the year check).
+	if data.ValidateMovie(v, movie, taxonomy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	resp := envelope{
+		"movie":         movie,
+		"reverted_from": before.Version,
+		"reverted_to":   input.Version,
+		"changes":       data.DiffMovies(&before, movie),
+	}
+
+	err = app.writeResponse(w, r, resp, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// corresponding endpoint: "GET /v1/movies/duplicates"
+// Lists the clusters of movies which are likely duplicates of each other (the same year & the same
+// or a very similar title), e.g. so curators can merge or delete them.
+func (app *application) listMovieDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// The clusters are always ordered by their oldest movie.
+	filters.Sort = "id"
+	filters.SortSafelist = []string{"id"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	clusters, metadata, err := app.models.Movies.GetDuplicateClusters(app.config.search.duplicateThreshold, filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, envelope{"movies": movies}, http.StatusOK, nil)
+	err = app.writeResponse(w, r, envelope{"clusters": clusters, "metadata": metadata}, http.StatusOK, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestUpdateMovieHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		runtime data.Runtime
+		title   string
+	}{
+		{
+			name:    "runtime",
+			body:    `{"runtime": 102}`,
+			status:  http.StatusOK,
+			runtime: 102,
+			title:   "Heat",
+		},
+		{
+			// Fields missing from the body are left as they are.
+			name:    "title only",
+			body:    `{"title": "Heat (1995)"}`,
+			status:  http.StatusOK,
+			runtime: 170,
+			title:   "Heat (1995)",
+		},
+		{
+			name:   "invalid runtime",
+			body:   `{"runtime": -5}`,
+			status: http.StatusUnprocessableEntity,
+		},
+	}
+
+	// The genre taxonomy is read from the database; the stub knows a single genre.
+	db := sql.OpenDB(stubConnector{rows: [][]driver.Value{{"drama", "drama"}}})
+	defer db.Close()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.Models{Genres: data.GenreModel{DB: db}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &stubMovieStore{movie: &data.Movie{ID: 1, Title: "Heat", Year: 1995, Runtime: 170, Genres: []string{"drama"}, Version: 1}}
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(tt.body))
+			r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: "1"}}))
+			r = app.contextSetMovieStore(r, store)
+
+			app.updateMovieHandler(rr, r)
+
+			if rr.Code != tt.status {
+				t.Fatalf("status = %d; want %d (%s)", rr.Code, tt.status, rr.Body)
+			}
+
+			if tt.status != http.StatusOK {
+				if store.updated != nil {
+					t.Errorf("the movie was updated; want it unchanged")
+				}
+				return
+			}
+
+			if store.updated == nil {
+				t.Fatal("the movie wasn't updated")
+			}
+
+			if store.updated.Runtime != tt.runtime || store.updated.Title != tt.title {
+				t.Errorf("updated to %q (%d mins); want %q (%d mins)", store.updated.Title, store.updated.Runtime, tt.title, tt.runtime)
+			}
+
+			var body struct {
+				Movie struct {
+					Runtime string `json:"runtime"`
+				} `json:"movie"`
+			}
+
+			err := json.NewDecoder(rr.Body).Decode(&body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := fmt.Sprintf("%d mins", tt.runtime); body.Movie.Runtime != want {
+				t.Errorf("response runtime = %q; want %q", body.Movie.Runtime, want)
+			}
+		})
+	}
+}
+
+func TestListMoviesHandlerCursor(t *testing.T) {
+	key := []byte("secret")
+
+	// Cursors are signed like the data package does; see data.Filters.ParseCursor.
+	sign := func(payload string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(payload))
+		return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	yearCursor := sign(`{"s":"year","k":"1995","i":12}`)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "garbage", query: "cursor=garbage"},
+		// Another id, with the signature of the original payload.
+		{name: "tampered", query: "sort=year&cursor=" + strings.Split(sign(`{"s":"year","k":"1995","i":13}`), ".")[0] + "." + strings.Split(yearCursor, ".")[1]},
+		{name: "bad signature", query: "sort=year&cursor=" + strings.Split(yearCursor, ".")[0] + ".AAAA"},
+		{name: "other sort", query: "sort=-year&cursor=" + yearCursor},
+	}
+
+	// Without a database: the cursor must be rejected before any query runs.
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	app.config.cursor.key = key
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies?"+tt.query, nil)
+
+			app.listMoviesHandler(rr, r)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d; want %d (%s)", rr.Code, http.StatusBadRequest, rr.Body)
+			}
+
+			if !strings.Contains(rr.Body.String(), data.ErrInvalidCursor.Error()) {
+				t.Errorf("body = %s; want the invalid cursor error", rr.Body)
+			}
+		})
+	}
+}
+
+func TestMovieWritesRequireAuthentication(t *testing.T) {
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	routes := app.routes()
+
+	// Every change to the movies requires the movies:write permission; anonymous users get a 401.
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/v1/movies"},
+		{http.MethodPatch, "/v1/movies/1"},
+		{http.MethodDelete, "/v1/movies/1"},
+		{http.MethodPost, "/v1/movies/1/revert"},
+		{http.MethodPost, "/v1/batch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{}`))
+
+			routes.ServeHTTP(rr, r)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d; want %d (%s)", rr.Code, http.StatusUnauthorized, rr.Body)
+			}
+		})
+	}
+}
+
+// A movieStore holding a single movie, recording the update.
+type stubMovieStore struct {
+	movie   *data.Movie
+	updated *data.Movie
+}
+
+func (s *stubMovieStore) Get(id int64) (*data.Movie, error) {
+	if id != s.movie.ID {
+		return nil, data.ErrRecordNotFound
+	}
+
+	movie := *s.movie
+	return &movie, nil
+}
+
+func (s *stubMovieStore) Update(movie *data.Movie) error {
+	movie.Version++
+	s.updated = movie
+	return nil
+}
+
+func (s *stubMovieStore) Insert(movie *data.Movie) error {
+	return errors.New("not implemented")
+}
+
+func (s *stubMovieStore) DeleteVersion(id int64, version int32) error {
+	return errors.New("not implemented")
+}
+
+func (s *stubMovieStore) FindDuplicates(movie *data.Movie, threshold float64) ([]*data.Movie, error) {
+	return nil, errors.New("not implemented")
+}
+
+// A database/sql driver answering every query with the same two-column rows.
+type stubConnector struct {
+	rows [][]driver.Value
+}
+
+func (c stubConnector) Connect(context.Context) (driver.Conn, error) { return stubConn(c), nil }
+func (c stubConnector) Driver() driver.Driver                        { return nil }
+
+type stubConn stubConnector
+
+func (c stubConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &stubRows{rows: c.rows}, nil
+}
+
+func (c stubConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (c stubConn) Close() error                        { return nil }
+func (c stubConn) Begin() (driver.Tx, error)           { return nil, errors.New("not implemented") }
+
+type stubRows struct {
+	rows [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return []string{"a", "b"} }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A response format the API can produce.
+// The first media type is the one sent in the Content-Type header; the rest are accepted aliases.
+type responseFormat struct {
+	mediaTypes  []string
+	contentType string
+	encode      func(tree any) ([]byte, error) // nil for JSON, which is written by writeJSON
+}
+
+// In order of preference when the client doesn't mind (e.g. "Accept: */*").
+var responseFormats = []*responseFormat{
+	{
+		mediaTypes:  []string{"application/json"},
+		contentType: "application/json",
+	},
+	{
+		mediaTypes:  []string{"application/xml", "text/xml"},
+		contentType: "application/xml; charset=utf-8",
+		encode:      encodeXML,
+	},
+	{
+		mediaTypes:  []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		contentType: "application/msgpack",
+		encode:      encodeMsgpack,
+	},
+	{
+		// Only lists & errors can be written as CSV (see encodeCSV).
+		mediaTypes:  []string{"text/csv"},
+		contentType: "text/csv; charset=utf-8",
+		encode:      encodeCSV,
+	},
+}
+
+var jsonFormat = responseFormats[0]
+
+// One entry of an Accept header, e.g. "text/csv;q=0.8".
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []mediaRange {
+	// A missing Accept header means any format is fine.
+	if strings.TrimSpace(header) == "" {
+		return []mediaRange{{"*/*", 1}}
+	}
+
+	var ranges []mediaRange
+
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+
+		mr := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(mediaType)), q: 1}
+		if mr.mediaType == "" {
+			continue
+		}
+
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(key) == "q" {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || q < 0 || q > 1 {
+					q = 0
+				}
+				mr.q = q
+			}
+		}
+
+		ranges = append(ranges, mr)
+	}
+
+	return ranges
+}
+
+// How specifically a media range matches a media type: 3 exact, 2 "type/*", 1 "*/*" & 0 no match.
+func matchSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 3
+	case mediaRange == "*/*":
+		return 1
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Returns the formats the client accepts, best first.
+// Each format takes the q-value of the most specific range matching it (so "*/*, text/csv;q=0" rules CSV out);
+// ties go to the more specific match & then to the server's preference.
+func acceptableFormats(header string) []*responseFormat {
+	ranges := parseAccept(header)
+
+	type candidate struct {
+		format      *responseFormat
+		q           float64
+		specificity int
+	}
+
+	var candidates []candidate
+
+	for _, format := range responseFormats {
+		best := candidate{format: format}
+
+		for _, mr := range ranges {
+			for _, mediaType := range format.mediaTypes {
+				s := matchSpecificity(mr.mediaType, mediaType)
+				if s > best.specificity || (s == best.specificity && s > 0 && mr.q > best.q) {
+					best.q, best.specificity = mr.q, s
+				}
+			}
+		}
+
+		if best.q > 0 {
+			candidates = append(candidates, best)
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.q != b.q:
+			if a.q > b.q {
+				return -1
+			}
+			return 1
+		default:
+			return b.specificity - a.specificity
+		}
+	})
+
+	formats := make([]*responseFormat, len(candidates))
+	for i, c := range candidates {
+		formats[i] = c.format
+	}
+
+	return formats
+}
+
+// Works out the acceptable response formats from the Accept header & adds them to the request context.
+// Requests accepting none of the supported formats get a (JSON) 406 Not Acceptable response.
+func (app *application) negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		formats := acceptableFormats(r.Header.Get("Accept"))
+		if len(formats) == 0 {
+			app.notAcceptableResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetFormats(r, formats))
+	})
+}
+
+// Writes the envelope in the best format the client accepts (JSON if it didn't say).
+// If no acceptable format can represent the data (e.g. a single movie as CSV), a 406 is sent instead.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, data envelope, status int, headers http.Header) error {
+	for _, format := range app.contextGetFormats(r) {
+		if format == jsonFormat {
+			return app.writeJSON(w, data, status, headers)
+		}
+
+		tree, err := toTree(data)
+		if err != nil {
+			return err
+		}
+
+		body, err := format.encode(tree)
+		if err != nil {
+			if errors.Is(err, errNotTabular) {
+				continue
+			}
+			return err
+		}
+
+		for key, val := range headers {
+			w.Header()[key] = val
+		}
+
+		w.Header().Set("Content-Type", format.contentType)
+		w.WriteHeader(status)
+		w.Write(body)
+
+		return nil
+	}
+
+	app.notAcceptableResponse(w, r)
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+)
+
+func TestAcceptableFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   []string // the content types, best first
+	}{
+		{
+			name:   "no header",
+			accept: "",
+			want:   []string{"application/json", "application/xml; charset=utf-8", "application/msgpack", "text/csv; charset=utf-8"},
+		},
+		{
+			name:   "exact",
+			accept: "text/csv",
+			want:   []string{"text/csv; charset=utf-8"},
+		},
+		{
+			name:   "alias",
+			accept: "text/xml",
+			want:   []string{"application/xml; charset=utf-8"},
+		},
+		{
+			name:   "case & whitespace",
+			accept: " Application/MsgPack ",
+			want:   []string{"application/msgpack"},
+		},
+		{
+			name:   "q-values",
+			accept: "application/json;q=0.5, text/csv;q=0.9, application/xml",
+			want:   []string{"application/xml; charset=utf-8", "text/csv; charset=utf-8", "application/json"},
+		},
+		{
+			// The more specific range wins, whatever the order.
+			name:   "wildcard with exclusion",
+			accept: "text/csv;q=0, */*",
+			want:   []string{"application/json", "application/xml; charset=utf-8", "application/msgpack"},
+		},
+		{
+			name:   "type wildcard",
+			accept: "text/*",
+			want:   []string{"application/xml; charset=utf-8", "text/csv; charset=utf-8"},
+		},
+		{
+			// Equal q-values: the exact match goes before the wildcard ones.
+			name:   "specificity breaks ties",
+			accept: "*/*, text/csv",
+			want:   []string{"text/csv; charset=utf-8", "application/json", "application/xml; charset=utf-8", "application/msgpack"},
+		},
+		{
+			name:   "wildcard with lower q",
+			accept: "*/*;q=0.1, application/msgpack",
+			want:   []string{"application/msgpack", "application/json", "application/xml; charset=utf-8", "text/csv; charset=utf-8"},
+		},
+		{
+			name:   "invalid q",
+			accept: "application/json;q=2, text/csv;q=abc",
+			want:   []string{},
+		},
+		{
+			name:   "unsupported",
+			accept: "image/png, text/html",
+			want:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, format := range acceptableFormats(tt.accept) {
+				got = append(got, format.contentType)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("acceptableFormats(%q) = %q; want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	movie := &data.Movie{ID: 1, Title: "Heat", Year: 1995, Runtime: 170, Genres: []string{"crime", "drama"}, Version: 1}
+
+	tests := []struct {
+		name        string
+		accept      string
+		data        envelope
+		status      int
+		contentType string
+		body        []string // fragments the body must contain
+	}{
+		{
+			name:        "json",
+			accept:      "application/json",
+			data:        envelope{"movie": movie},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        []string{`"runtime": "170 mins"`},
+		},
+		{
+			name:        "xml",
+			accept:      "application/xml",
+			data:        envelope{"movie": movie},
+			status:      http.StatusOK,
+			contentType: "application/xml; charset=utf-8",
+			body:        []string{"<response>", "<runtime>170 mins</runtime>", "<genres>\n\t\t\t<item>crime</item>"},
+		},
+		{
+			name:        "csv list",
+			accept:      "text/csv",
+			data:        envelope{"movies": []*data.Movie{movie}, "metadata": data.Metadata{}},
+			status:      http.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			body:        []string{"id,title,year,runtime,genres,version,average_rating,rating_count\n", `1,Heat,1995,170 mins,"crime,drama",1,0,0`},
+		},
+		{
+			// A single movie has no tabular form; the next acceptable format is used instead.
+			name:        "csv falls back",
+			accept:      "text/csv, application/xml;q=0.5",
+			data:        envelope{"movie": movie},
+			status:      http.StatusOK,
+			contentType: "application/xml; charset=utf-8",
+			body:        []string{"<title>Heat</title>"},
+		},
+		{
+			// ... & with no other format, the 406 error itself is written as CSV.
+			name:        "csv only",
+			accept:      "text/csv",
+			data:        envelope{"movie": movie},
+			status:      http.StatusNotAcceptable,
+			contentType: "text/csv; charset=utf-8",
+			body:        []string{"error\n", "not available in any of the formats"},
+		},
+		{
+			name:        "validation errors as csv",
+			accept:      "text/csv",
+			data:        envelope{"error": map[string]string{"title": "must be provided"}},
+			status:      http.StatusUnprocessableEntity,
+			contentType: "text/csv; charset=utf-8",
+			body:        []string{"field,error\n", "title,must be provided\n"},
+		},
+		{
+			// Nothing acceptable at all: the middleware answers in JSON.
+			name:        "not acceptable",
+			accept:      "image/png",
+			data:        envelope{"movie": movie},
+			status:      http.StatusNotAcceptable,
+			contentType: "application/json",
+			body:        []string{`"error": "The requested resource is not available`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := app.negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				err := app.writeResponse(w, r, tt.data, tt.status, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}))
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+			r.Header.Set("Accept", tt.accept)
+
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != tt.status {
+				t.Errorf("status = %d; want %d", rr.Code, tt.status)
+			}
+
+			if got := rr.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q; want %q", got, tt.contentType)
+			}
+
+			if got := rr.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("Vary = %q; want Accept", got)
+			}
+
+			for _, fragment := range tt.body {
+				if !strings.Contains(rr.Body.String(), fragment) {
+					t.Errorf("body doesn't contain %q:\n%s", fragment, rr.Body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// The OpenAPI 3.1 description of every route in routes.go.
+// Keep it in step with the handlers; openapi_test.go fails for routes missing from it.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// corresponding endpoint: "GET /v1/openapi.json"
+// The document is JSON by nature, so it's served as-is whatever the Accept header says.
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
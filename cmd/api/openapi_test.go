@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type route struct {
+	method string
+	path   string // in OpenAPI form, e.g. "/v1/movies/{id}"
+}
+
+// Reads the routes registered in routes.go, i.e. every router.HandlerFunc(http.MethodX, "/path", ...)
+// & router.Handler(...) call, with httprouter's ":name" parameters rewritten as "{name}",
+// plus the mux.HandleFunc("METHOD /path", ...) & mux.Handle(...) calls with a method in the pattern.
+func registeredRoutes(t *testing.T) []route {
+	t.Helper()
+
+	file, err := parser.ParseFile(token.NewFileSet(), "routes.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	param := regexp.MustCompile(`:(\w+)`)
+	var routes []route
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) < 2 {
+			return true
+		}
+
+		fn, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+
+		recv, ok := fn.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+
+		if recv.Name == "mux" && (fn.Sel.Name == "HandleFunc" || fn.Sel.Name == "Handle") {
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok {
+				t.Fatalf("unexpected pattern argument in routes.go: %#v", call.Args[0])
+			}
+
+			pattern, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// "/" (everything else) is the router.
+			if method, path, ok := strings.Cut(pattern, " "); ok {
+				routes = append(routes, route{method: strings.ToLower(method), path: path})
+			}
+
+			return true
+		}
+
+		if recv.Name != "router" || (fn.Sel.Name != "HandlerFunc" && fn.Sel.Name != "Handler") {
+			return true
+		}
+
+		method, ok := call.Args[0].(*ast.SelectorExpr)
+		if !ok {
+			t.Fatalf("unexpected method argument in routes.go: %#v", call.Args[0])
+		}
+
+		lit, ok := call.Args[1].(*ast.BasicLit)
+		if !ok {
+			t.Fatalf("unexpected path argument in routes.go: %#v", call.Args[1])
+		}
+
+		path, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		routes = append(routes, route{
+			method: strings.ToLower(strings.TrimPrefix(method.Sel.Name, "Method")),
+			path:   param.ReplaceAllString(path, "{$1}"),
+		})
+
+		return true
+	})
+
+	if len(routes) == 0 {
+		t.Fatal("no routes found in routes.go")
+	}
+
+	return routes
+}
+
+type openAPIDocument struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+func loadOpenAPISpec(t *testing.T) openAPIDocument {
+	t.Helper()
+
+	var doc openAPIDocument
+
+	err := json.Unmarshal(openAPISpec, &doc)
+	if err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %s", err)
+	}
+
+	return doc
+}
+
+func TestOpenAPISpecCoversEveryRoute(t *testing.T) {
+	doc := loadOpenAPISpec(t)
+
+	for _, r := range registeredRoutes(t) {
+		if _, ok := doc.Paths[r.path][r.method]; !ok {
+			t.Errorf("%s %s is registered in routes.go but missing from openapi.json", strings.ToUpper(r.method), r.path)
+		}
+	}
+}
+
+func TestOpenAPISpecHasNoStaleRoutes(t *testing.T) {
+	doc := loadOpenAPISpec(t)
+
+	registered := make(map[route]bool)
+	for _, r := range registeredRoutes(t) {
+		registered[r] = true
+	}
+
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if method == "parameters" || method == "summary" || method == "description" {
+				continue
+			}
+
+			if !registered[route{method, path}] {
+				t.Errorf("openapi.json describes %s %s, which isn't registered in routes.go", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPISpecReferencesResolve(t *testing.T) {
+	var doc map[string]any
+
+	err := json.Unmarshal(openAPISpec, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc["openapi"] != "3.1.0" {
+		t.Errorf("openapi = %v; want 3.1.0", doc["openapi"])
+	}
+
+	var walk func(node any)
+	walk = func(node any) {
+		switch v := node.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				if !resolvesTo(doc, ref) {
+					t.Errorf("unresolved $ref %q", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+
+	walk(doc)
+}
+
+// Follows a local reference, e.g. "#/components/schemas/Movie".
+func resolvesTo(doc map[string]any, ref string) bool {
+	if !strings.HasPrefix(ref, "#/") {
+		return false
+	}
+
+	var node any = doc
+	for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return false
+		}
+		if node, ok = obj[key]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	app := &application{}
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil)
+
+	app.routes().ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q; want application/json", got)
+	}
+
+	if !json.Valid(rr.Body.Bytes()) {
+		t.Error("response body is not valid JSON")
+	}
+}
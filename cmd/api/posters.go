@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/internal/storage"
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+
+	// Register the decoders of the accepted poster formats with image.Decode() (image/jpeg does for JPEG).
+	_ "golang.org/x/image/webp"
+	_ "image/png"
+)
+
+// The accepted poster formats (as named by image.Decode) & the file extensions they're stored with.
+var posterExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"webp": ".webp",
+}
+
+var errPosterTooLarge = errors.New("poster too large")
+
+// corresponding endpoint: "PUT /v1/movies/:id/poster"
+// Uploads (or replaces) the movie's poster: a JPEG, PNG or WebP image, sent as the request body or as
+// the "poster" file of a multipart form, e.g.
+//
+//	curl -X PUT -H "Authorization: Bearer $TOKEN" --data-binary @poster.jpg localhost:4000/v1/movies/1/poster
+//	curl -X PUT -H "Authorization: Bearer $TOKEN" -F poster=@poster.jpg localhost:4000/v1/movies/1/poster
+//
+// The original is stored as-is, along with JPEG thumbnails of the data.PosterThumbnailWidths.
+// Requires the "movies:write" permission.
+func (app *application) putMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Before reading what could be megabytes of image.
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	body, err := app.readPoster(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPosterTooLarge):
+			msg := fmt.Sprintf("The poster must not be larger than %d bytes.", app.config.posters.maxSize)
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, msg)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the header is decoded at first, so oversized images are refused before they take up any memory.
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(body))
+	ext, ok := posterExtensions[format]
+	if err != nil || !ok {
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, "The poster must be a JPEG, PNG or WebP image.")
+		return
+	}
+
+	maxDimension := app.config.posters.maxDimension
+
+	v := validator.New()
+	v.Check(cfg.Width <= maxDimension && cfg.Height <= maxDimension, "poster", fmt.Sprintf("must not be larger than %dx%d pixels", maxDimension, maxDimension))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The key changes with the image, so the poster URLs can be cached for good (see showPosterHandler).
+	// It's unique to the upload too: concurrent uploads never share files, so the loser of the race
+	// (see SetPoster) can delete its own.
+	sum := sha256.Sum256(body)
+	prefix := fmt.Sprintf("%d/%x-", id, sum[:8])
+
+	// The same image uploaded again; nothing changes.
+	if strings.HasPrefix(movie.Poster, prefix) {
+		err = app.writeResponse(w, r, envelope{"movie": movie}, http.StatusOK, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	nonce := make([]byte, 4)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key := fmt.Sprintf("%s%x%s", prefix, nonce, ext)
+
+	keys, err := app.storePoster(key, body, img)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previous := movie.Poster
+
+	movie, err = app.models.Movies.SetPoster(id, previous, key)
+	if err != nil {
+		// The movie was deleted or its poster replaced meanwhile, or the poster couldn't be recorded;
+		// either way this one is unused.
+		app.deletePosterFiles(keys...)
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Nothing refers to the previous poster anymore.
+	if previous != "" {
+		app.deletePosterFiles(posterKeys(previous)...)
+	}
+
+	err = app.writeResponse(w, r, envelope{"movie": movie}, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Reads the uploaded image: the "poster" file of a multipart form, or else the raw request body.
+// Returns errPosterTooLarge if it's bigger than the configured maximum.
+func (app *application) readPoster(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	maxSize := app.config.posters.maxSize
+
+	// Leave some room for the multipart headers & boundaries.
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+64*1024)
+
+	var src io.Reader = r.Body
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				var maxBytesError *http.MaxBytesError
+				switch {
+				case errors.Is(err, io.EOF):
+					return nil, errors.New("the form must contain a poster file")
+				case errors.As(err, &maxBytesError):
+					return nil, errPosterTooLarge
+				default:
+					return nil, err
+				}
+			}
+
+			if part.FormName() == "poster" {
+				src = part
+				break
+			}
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(src, maxSize+1))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			return nil, errPosterTooLarge
+		default:
+			return nil, err
+		}
+	}
+
+	switch {
+	case len(body) == 0:
+		return nil, errors.New("the poster image must be provided")
+	case int64(len(body)) > maxSize:
+		return nil, errPosterTooLarge
+	}
+
+	return body, nil
+}
+
+// Stores the original image & its thumbnails, returning their keys.
+// Nothing is left behind on failure.
+func (app *application) storePoster(key string, original []byte, img image.Image) ([]string, error) {
+	keys := []string{key}
+
+	err := app.posters.Put(key, bytes.NewReader(original))
+	if err != nil {
+		return nil, err
+	}
+
+	flat := flatten(img)
+
+	for _, width := range data.PosterThumbnailWidths {
+		var buf bytes.Buffer
+
+		err = jpeg.Encode(&buf, downscale(flat, width), &jpeg.Options{Quality: 85})
+		if err == nil {
+			thumbnailKey := data.PosterThumbnailKey(key, width)
+			keys = append(keys, thumbnailKey)
+			err = app.posters.Put(thumbnailKey, &buf)
+		}
+
+		if err != nil {
+			app.deletePosterFiles(keys...)
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// The keys of a stored poster & its thumbnails.
+func posterKeys(key string) []string {
+	keys := []string{key}
+	for _, width := range data.PosterThumbnailWidths {
+		keys = append(keys, data.PosterThumbnailKey(key, width))
+	}
+
+	return keys
+}
+
+// Failures are only logged; the files are merely left unused.
+func (app *application) deletePosterFiles(keys ...string) {
+	for _, key := range keys {
+		err := app.posters.Delete(key)
+		if err != nil {
+			app.logger.Error(err.Error(), "poster", key)
+		}
+	}
+}
+
+// Draws the image onto a white background (JPEG has no transparency), into an RGBA image whose
+// pixels downscale() can read directly.
+func flatten(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, b.Min, draw.Over)
+
+	return flat
+}
+
+// Scales the (opaque) image down to the width, keeping its aspect ratio. Each pixel of the result
+// is the average of the source pixels it covers (a box filter), which holds up well for
+// downscaling. Images which are narrow enough already are returned as they are.
+func downscale(src *image.RGBA, width int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if width >= sw {
+		return src
+	}
+
+	height := max(1, int(math.Round(float64(sh)*float64(width)/float64(sw))))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0 := y * sh / height
+		y1 := max((y+1)*sh/height, y0+1)
+
+		for x := range width {
+			x0 := x * sw / width
+			x1 := max((x+1)*sw/width, x0+1)
+
+			var r, g, b int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					i += 4
+				}
+			}
+
+			n := (x1 - x0) * (y1 - y0)
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(b / n)
+			dst.Pix[o+3] = 0xff
+		}
+	}
+
+	return dst
+}
+
+// corresponding endpoint: "GET /v1/posters/{movie_id}/{file}"
+// Serves the posters & their thumbnails (see the movies' poster_url & poster_thumbnail_urls).
+// A poster's URL changes whenever it's replaced, so the files never change & may be cached for good.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+
+	obj, err := app.posters.Open(r.PathValue("movie_id") + "/" + file)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer obj.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", strconv.Quote(strings.TrimSuffix(file, path.Ext(file))))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// Sets the Content-Type from the extension & handles conditional & range requests.
+	http.ServeContent(w, r, file, obj.ModTime, obj)
+}
+
+// Deletes the posters of the deleted movies.
+func (app *application) deleteMoviePosters(_ *data.OutboxTx, e *data.OutboxEvent) error {
+	if e.Event != data.EventMovieDeleted {
+		return nil
+	}
+
+	var payload struct {
+		Movie struct {
+			ID int64 `json:"id"`
+		} `json:"movie"`
+	}
+
+	err := json.Unmarshal(e.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	return app.posters.DeleteAll(strconv.FormatInt(payload.Movie.ID, 10))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDownscale(t *testing.T) {
+	// 4x2: the left half black, the right half white.
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := range 2 {
+		for x := range 4 {
+			c := color.RGBA{0, 0, 0, 0xff}
+			if x >= 2 {
+				c = color.RGBA{0xff, 0xff, 0xff, 0xff}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	dst := downscale(src, 2)
+	if got := dst.Bounds().Size(); got != image.Pt(2, 1) {
+		t.Fatalf("got size %v; want (2,1)", got)
+	}
+
+	if got := dst.RGBAAt(0, 0); got != (color.RGBA{0, 0, 0, 0xff}) {
+		t.Errorf("got left pixel %v; want black", got)
+	}
+
+	if got := dst.RGBAAt(1, 0); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("got right pixel %v; want white", got)
+	}
+
+	// Averages across the halves.
+	if got := downscale(src, 1).RGBAAt(0, 0); got != (color.RGBA{0x7f, 0x7f, 0x7f, 0xff}) {
+		t.Errorf("got %v; want mid-gray", got)
+	}
+
+	// Not upscaled.
+	if got := downscale(src, 8); got != src {
+		t.Errorf("got a new image; want the narrower source as it is")
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	// Transparent pixels turn white, as JPEG has no alpha channel.
+	src := image.NewNRGBA(image.Rect(10, 10, 12, 11))
+	src.Set(11, 10, color.NRGBA{0xff, 0, 0, 0xff})
+
+	flat := flatten(src)
+
+	if got := flat.RGBAAt(0, 0); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("got transparent pixel %v; want white", got)
+	}
+
+	if got := flat.RGBAAt(1, 0); got != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("got opaque pixel %v; want red", got)
+	}
+}
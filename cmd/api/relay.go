@@ -0,0 +1,156 @@
+package main
+
+import (
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/lib/pq"
+)
+
+// The events handed to a subscriber per round trip to the database.
+const outboxBatchSize = 100
+
+// A consumer of the outbox events.
+//
+// Durable subscribers keep their checkpoint in the database, shared by all the API instances:
+// every event is handled once (by one of them), & a restart resumes where the last one left off.
+// Live subscribers keep it in memory: every instance sees every event written after it started.
+type outboxSubscriber struct {
+	name    string
+	durable bool
+	// Durable subscribers get the transaction moving their checkpoint; live ones get nil.
+	handle func(*data.OutboxTx, *data.OutboxEvent) error
+	// Durable subscribers only, optional: called once the handled events are committed.
+	committed func()
+
+	// Live subscribers only.
+	started    bool
+	checkpoint int64
+}
+
+func (app *application) outboxSubscribers() []*outboxSubscriber {
+	return []*outboxSubscriber{
+		{name: "webhooks", durable: true, handle: app.dispatchWebhooks, committed: app.wakeWebhookDeliveries},
+		{name: "posters", durable: true, handle: app.deleteMoviePosters},
+		{name: "event-stream", handle: app.streamOutboxEvent},
+	}
+}
+
+// Sends the event to this instance's event stream clients.
+func (app *application) streamOutboxEvent(_ *data.OutboxTx, e *data.OutboxEvent) error {
+	_, body, err := newChangeEvent(e)
+	if err != nil {
+		return err
+	}
+
+	app.events.publish(e.Event, body)
+
+	return nil
+}
+
+// Hands the outbox events to the subscribers until the shutdown starts. The events are written
+// by the transactions changing the movies (whichever process makes them, e.g. moviectl too), &
+// Postgres notifies the relay of new ones; the polling catches up if a notification is missed
+// (e.g. while the listener reconnects).
+func (app *application) runOutboxRelay() {
+	subscribers := app.outboxSubscribers()
+
+	listener := pq.NewListener(app.config.db.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.Warn("outbox listener", "error", err.Error())
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen("outbox_events")
+	if err != nil {
+		app.logger.Warn("not listening for outbox notifications; polling only", "error", err.Error())
+	}
+
+	poll := time.NewTicker(app.config.outbox.pollInterval)
+	defer poll.Stop()
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		app.relayOutbox(subscribers)
+
+		select {
+		case <-listener.Notify:
+			// N.B. a nil notification means the listener reconnected; catch up all the same.
+		case <-poll.C:
+		case <-prune.C:
+			app.pruneOutbox()
+		case <-app.shutdown:
+			return
+		}
+	}
+}
+
+// Drains the pending events of every subscriber. A failing subscriber is retried on the next round,
+// from the event that failed.
+func (app *application) relayOutbox(subscribers []*outboxSubscriber) {
+	for _, sub := range subscribers {
+		for {
+			handled, err := app.relaySubscriber(sub)
+			if err != nil {
+				app.logger.Error(err.Error(), "subscriber", sub.name)
+				break
+			}
+
+			if handled < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+func (app *application) relaySubscriber(sub *outboxSubscriber) (handled int, err error) {
+	if sub.durable {
+		handled, err = app.models.Outbox.Process(sub.name, outboxBatchSize, sub.handle)
+		if handled > 0 && sub.committed != nil {
+			sub.committed()
+		}
+
+		return handled, err
+	}
+
+	// Live subscribers start with the events written after the relay started.
+	if !sub.started {
+		sub.checkpoint, err = app.models.Outbox.LatestID()
+		if err != nil {
+			return 0, err
+		}
+		sub.started = true
+	}
+
+	events, err := app.models.Outbox.GetAfter(sub.checkpoint, outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range events {
+		err = sub.handle(nil, e)
+		if err != nil {
+			return handled, err
+		}
+
+		sub.checkpoint = e.ID
+		handled++
+	}
+
+	return handled, nil
+}
+
+func (app *application) pruneOutbox() {
+	deleted, err := app.models.Outbox.DeleteOlderThan(app.config.outbox.retention)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	if deleted > 0 {
+		app.logger.Info("pruned the outbox", "events", deleted)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -13,13 +14,73 @@ func (app *application) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	// Register the relevant methods, URL patterns & handler functions for our endpoints.
+	// The POST routes honor the Idempotency-Key header (see idempotent()); except for the token
+	// creation, whose response (a secret) mustn't be stored.
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	// Application metrics (e.g. the movie cache hit & miss counters), published in main().
+	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	// The OpenAPI description of all of the routes below.
+	router.HandlerFunc(http.MethodGet, "/v1/openapi.json", app.openAPIHandler)
+	// Anyone may read the movies; every change to them (whatever the endpoint) requires "movies:write".
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)))
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.listMoviesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revert", app.requirePermission("movies:write", app.idempotent(app.revertMovieHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.putMoviePosterHandler))
+	// Several movie creates, updates & deletes in one request.
+	router.HandlerFunc(http.MethodPost, "/v1/batch", app.requirePermission("movies:write", app.idempotent(app.batchHandler)))
 
-	// Wrap the router with the panic recovery middleware.
-	return app.recoverPanic(router)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.listMovieReviewsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requireAuthenticatedUser(app.idempotent(app.createReviewHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requireAuthenticatedUser(app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requireAuthenticatedUser(app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.listMovieCreditsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("people:write", app.idempotent(app.createMovieCreditHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission("people:write", app.deleteMovieCreditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/translations", app.listMovieTranslationsHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/translations/:locale", app.requirePermission("movies:write", app.putMovieTranslationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/translations/:locale", app.requirePermission("movies:write", app.deleteMovieTranslationHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.listGenresHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("genres:write", app.idempotent(app.createGenreHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:slug", app.requirePermission("genres:write", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres/:slug/merge", app.requirePermission("genres:write", app.idempotent(app.mergeGenreHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.listPeopleHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("people:write", app.idempotent(app.createPersonHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.showPersonHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("people:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("people:write", app.deletePersonHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/me/watchlist", app.requireAuthenticatedUser(app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/me/watchlist/:movie_id", app.requireAuthenticatedUser(app.putWatchlistItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/me/watchlist/:movie_id", app.requireAuthenticatedUser(app.deleteWatchlistItemHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:write", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:write", app.idempotent(app.createWebhookHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("webhooks:write", app.listWebhookDeliveriesHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.idempotent(app.registerUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	// The event stream is routed ahead of the router: httprouter can't register "/v1/movies/events"
+	// next to "/v1/movies/:id", & the stream isn't a response format to negotiate.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/movies/events", app.movieEventsHandler)
+	// Likewise "/v1/movies/duplicates"; it does go through the content negotiation though.
+	mux.Handle("GET /v1/movies/duplicates", app.negotiate(app.authenticate(http.HandlerFunc(app.listMovieDuplicatesHandler))))
+	// The poster images aren't negotiated either; "Accept: image/*" is fine for them.
+	mux.HandleFunc("GET /v1/posters/{movie_id}/{file}", app.showPosterHandler)
+	mux.Handle("/", app.negotiate(app.authenticate(router)))
+
+	// Wrap everything with the compression & panic recovery middleware;
+	// the router also with the content negotiation & authentication middleware.
+	return app.compress(app.recoverPanic(mux))
 }
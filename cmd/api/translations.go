@@ -0,0 +1,177 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// corresponding endpoint: "GET /v1/movies/:id/translations"
+func (app *application) listMovieTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	translations, err := app.models.Translations.GetAllForMovie(movieID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, envelope{"translations": translations}, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// corresponding endpoint: "PUT /v1/movies/:id/translations/:locale"
+// Adds the translation (201) or replaces the existing one (200).
+// e.g. curl -X PUT -d '{"title": "Die Verurteilten"}' localhost:4000/v1/movies/1/translations/de
+func (app *application) putMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Title    string `json:"title"`
+		Overview string `json:"overview"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	translation := &data.MovieTranslation{
+		MovieID: movieID,
+		// "pt_br" & "pt-br" are both stored as "pt-BR".
+		Locale:   data.NormalizeLocale(httprouter.ParamsFromContext(r.Context()).ByName("locale")),
+		Title:    input.Title,
+		Overview: input.Overview,
+	}
+
+	v := validator.New()
+	if data.ValidateTranslation(v, translation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	created, err := app.models.Translations.Upsert(translation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	headers := make(http.Header)
+
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d/translations/%s", movieID, translation.Locale))
+	}
+
+	err = app.writeResponse(w, r, envelope{"translation": translation}, status, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// corresponding endpoint: "DELETE /v1/movies/:id/translations/:locale"
+func (app *application) deleteMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	locale := data.NormalizeLocale(httprouter.ParamsFromContext(r.Context()).ByName("locale"))
+
+	err = app.models.Translations.Delete(movieID, locale)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, envelope{"message": "translation successfully deleted"}, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The locales to translate the movie titles into, most preferred first: those of the "lang"
+// parameter (e.g. "lang=pt-BR,en"), or else those of the Accept-Language header. An invalid "lang"
+// is reported to v; the header's invalid entries (& "*") are ignored.
+//
+// The base language of a regional locale is tried right after it (unless it's listed already),
+// so "de-AT" falls back to "de". An empty result means the original titles.
+func (app *application) readLocales(r *http.Request, v *validator.Validator) []string {
+	var tags []string
+
+	if lang := app.readCSVString(r.URL.Query(), "lang", nil); lang != nil {
+		for _, tag := range lang {
+			tag = data.NormalizeLocale(tag)
+			data.ValidateLocale(v, "lang", tag)
+			tags = append(tags, tag)
+		}
+	} else {
+		// Accept-Language has the same syntax as Accept, e.g. "de-AT, de;q=0.9, en;q=0.5".
+		ranges := parseAccept(r.Header.Get("Accept-Language"))
+		slices.SortStableFunc(ranges, func(a, b mediaRange) int {
+			return cmp.Compare(b.q, a.q)
+		})
+
+		for _, mr := range ranges {
+			tag := data.NormalizeLocale(mr.mediaType)
+			if mr.q > 0 && validator.Matches(tag, data.LocaleRX) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	var locales []string
+
+	for _, tag := range tags {
+		if !slices.Contains(locales, tag) {
+			locales = append(locales, tag)
+		}
+
+		if base, _, found := strings.Cut(tag, "-"); found && !slices.Contains(tags, base) && !slices.Contains(locales, base) {
+			locales = append(locales, base)
+		}
+	}
+
+	return locales
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+)
+
+func TestReadLocales(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		want           []string
+		valid          bool
+	}{
+		{name: "none", want: nil, valid: true},
+		{name: "header by q-value", acceptLanguage: "en;q=0.5, de-AT, fr;q=0", want: []string{"de-AT", "de", "en"}, valid: true},
+		{name: "base language listed later", acceptLanguage: "de-AT, en;q=0.8, de;q=0.5", want: []string{"de-AT", "en", "de"}, valid: true},
+		{name: "invalid header entries", acceptLanguage: "*, english, pt_br", want: []string{"pt-BR", "pt"}, valid: true},
+		{name: "lang overrides the header", query: "lang=PT_br,en", acceptLanguage: "de", want: []string{"pt-BR", "pt", "en"}, valid: true},
+		{name: "invalid lang", query: "lang=english", valid: false},
+	}
+
+	app := &application{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1/movies?"+tt.query, nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+
+			v := validator.New()
+			got := app.readLocales(r, v)
+
+			if v.Valid() != tt.valid {
+				t.Fatalf("got valid %t; want %t (%v)", v.Valid(), tt.valid, v.Errors)
+			}
+
+			if tt.valid && !slices.Equal(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+)
+
+// corresponding endpoint: "PUT /v1/me/watchlist/:movie_id"
+// Adds the movie to the user's watchlist; an empty body is fine.
+// e.g. curl -X PUT -d '{"watched": true, "watched_at": "2024-05-01"}' localhost:4000/v1/me/watchlist/1
+func (app *application) putWatchlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Start from the existing item (if any), so omitted fields keep their values.
+	item, err := app.models.Watchlist.Get(user.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			item = &data.WatchlistItem{UserID: user.ID, MovieID: movieID}
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	var input struct {
+		Watched   *bool      `json:"watched"`
+		WatchedAt *data.Date `json:"watched_at"`
+	}
+
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	// A watched date on its own implies the movie was watched.
+	if input.WatchedAt != nil && input.Watched == nil {
+		watched := true
+		input.Watched = &watched
+	}
+
+	if input.Watched != nil {
+		item.Watched = *input.Watched
+
+		switch {
+		case !item.Watched:
+			item.WatchedAt = nil
+		case input.WatchedAt != nil:
+			item.WatchedAt = input.WatchedAt
+		case item.WatchedAt == nil:
+			// Default to today.
+			today := data.Date(time.Now())
+			item.WatchedAt = &today
+		}
+	}
+
+	v := validator.New()
+	if data.ValidateWatchlistItem(v, item); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	created, err := app.models.Watchlist.Upsert(item)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	err = app.writeResponse(w, r, envelope{"watchlist_item": item}, status, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// corresponding endpoint: "DELETE /v1/me/watchlist/:movie_id"
+func (app *application) deleteWatchlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Watchlist.Delete(app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, envelope{"message": "movie successfully removed from the watchlist"}, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// corresponding endpoint: "GET /v1/me/watchlist"
+// Supports the same page, page_size & sort parameters as "GET /v1/movies", plus "watched=true|false".
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Watched *bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	if s := qs.Get("watched"); s != "" {
+		watched, err := strconv.ParseBool(s)
+		if err != nil {
+			v.AddError("watched", "must be a boolean value")
+		}
+		input.Watched = &watched
+	}
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 10, v)
+
+	// By default the most recently added movies come first.
+	input.Filters.Sort = app.readString(qs, "sort", "-added_at")
+	input.Filters.SortSafelist = []string{
+		"added_at", "title", "year", "runtime", "watched_at",
+		"-added_at", "-title", "-year", "-runtime", "-watched_at",
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	items, metadata, err := app.models.Watchlist.GetAll(app.contextGetUser(r).ID, input.Watched, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, envelope{"watchlist": items, "metadata": metadata}, http.StatusOK, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
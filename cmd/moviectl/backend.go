@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/pkg/moviesclient"
+)
+
+// Where the movie commands read & write: the API (see apiBackend) or the database (see dbBackend).
+// Both work with data.Movie, so the commands can validate with the same rules as the API.
+type backend interface {
+	ListMovies(search data.MovieSearch, filters data.Filters) ([]*data.Movie, data.Metadata, error)
+	GetMovie(id int64) (*data.Movie, error)
+	// Unless allowDuplicate is set, the API refuses movies which look like existing ones.
+	CreateMovie(movie *data.Movie, allowDuplicate bool) error
+	// Returns data.ErrEditConflict if the movie is no longer at movie.Version.
+	UpdateMovie(movie *data.Movie) error
+	DeleteMovie(id int64) error
+	Taxonomy() (*data.GenreTaxonomy, error)
+}
+
+// Works directly on the database, through the same models as the API.
+// N.B. the API's movie cache doesn't see these changes until its entries expire.
+type dbBackend struct {
+	models data.Models
+}
+
+func (b dbBackend) ListMovies(search data.MovieSearch, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	return b.models.Movies.GetMovies(search, filters)
+}
+
+func (b dbBackend) GetMovie(id int64) (*data.Movie, error) {
+	return b.models.Movies.Get(id)
+}
+
+// N.B. the duplicate check is the API's; the database takes any (valid) movie.
+func (b dbBackend) CreateMovie(movie *data.Movie, allowDuplicate bool) error {
+	return b.models.Movies.Insert(movie)
+}
+
+func (b dbBackend) UpdateMovie(movie *data.Movie) error {
+	return b.models.Movies.Update(movie)
+}
+
+func (b dbBackend) DeleteMovie(id int64) error {
+	return b.models.Movies.Delete(id)
+}
+
+func (b dbBackend) Taxonomy() (*data.GenreTaxonomy, error) {
+	return b.models.Genres.Taxonomy()
+}
+
+// Goes through the API with the Go client; the API validates everything once more.
+type apiBackend struct {
+	client  *moviesclient.Client
+	timeout time.Duration
+}
+
+func (b apiBackend) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), b.timeout)
+}
+
+func (b apiBackend) ListMovies(search data.MovieSearch, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	opts := moviesclient.ListOptions{
+		Title:      search.Title,
+		Fuzzy:      search.Fuzzy,
+		Genres:     search.Genres,
+		AnyGenre:   search.AnyGenre,
+		PersonID:   search.PersonID,
+		YearMin:    search.YearMin,
+		YearMax:    search.YearMax,
+		RuntimeMin: moviesclient.Runtime(search.RuntimeMin),
+		RuntimeMax: moviesclient.Runtime(search.RuntimeMax),
+		Sort:       filters.Sort,
+		Page:       filters.Page,
+		PageSize:   filters.PageSize,
+	}
+
+	movies, metadata, err := b.client.ListMovies(ctx, opts)
+	if err != nil {
+		return nil, data.Metadata{}, apiError(err)
+	}
+
+	result := make([]*data.Movie, len(movies))
+	for i, movie := range movies {
+		result[i] = fromClientMovie(movie)
+	}
+
+	return result, data.Metadata{
+		CurrentPage:  metadata.CurrentPage,
+		PageSize:     metadata.PageSize,
+		FirstPage:    metadata.FirstPage,
+		LastPage:     metadata.LastPage,
+		TotalRecords: metadata.TotalRecords,
+	}, nil
+}
+
+func (b apiBackend) GetMovie(id int64) (*data.Movie, error) {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	movie, err := b.client.GetMovie(ctx, id)
+	if err != nil {
+		return nil, apiError(err)
+	}
+
+	return fromClientMovie(movie), nil
+}
+
+func (b apiBackend) CreateMovie(movie *data.Movie, allowDuplicate bool) error {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	created, err := b.client.CreateMovie(ctx, moviesclient.MovieInput{
+		Title:          movie.Title,
+		Year:           movie.Year,
+		Runtime:        moviesclient.Runtime(movie.Runtime),
+		Genres:         movie.Genres,
+		AllowDuplicate: allowDuplicate,
+	})
+	if err != nil {
+		return apiError(err)
+	}
+
+	*movie = *fromClientMovie(created)
+	return nil
+}
+
+func (b apiBackend) UpdateMovie(movie *data.Movie) error {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	runtime := moviesclient.Runtime(movie.Runtime)
+
+	updated, err := b.client.UpdateMovie(ctx, movie.ID, movie.Version, moviesclient.MovieUpdate{
+		Title:   &movie.Title,
+		Year:    &movie.Year,
+		Runtime: &runtime,
+		Genres:  movie.Genres,
+	})
+	if err != nil {
+		return apiError(err)
+	}
+
+	*movie = *fromClientMovie(updated)
+	return nil
+}
+
+func (b apiBackend) DeleteMovie(id int64) error {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	return apiError(b.client.DeleteMovie(ctx, id))
+}
+
+func (b apiBackend) Taxonomy() (*data.GenreTaxonomy, error) {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	genres, err := b.client.ListGenres(ctx)
+	if err != nil {
+		return nil, apiError(err)
+	}
+
+	converted := make([]*data.Genre, len(genres))
+	for i, genre := range genres {
+		converted[i] = &data.Genre{Slug: genre.Slug, Name: genre.Name, Aliases: genre.Aliases}
+	}
+
+	return data.NewGenreTaxonomy(converted), nil
+}
+
+func fromClientMovie(movie *moviesclient.Movie) *data.Movie {
+	return &data.Movie{
+		ID:            movie.ID,
+		Title:         movie.Title,
+		Year:          movie.Year,
+		Runtime:       data.Runtime(movie.Runtime),
+		Genres:        movie.Genres,
+		Version:       movie.Version,
+		AverageRating: movie.AverageRating,
+		RatingCount:   movie.RatingCount,
+	}
+}
+
+// Maps the client errors onto the data errors, so the commands handle both backends alike.
+func apiError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, moviesclient.ErrNotFound):
+		return data.ErrRecordNotFound
+	case errors.Is(err, moviesclient.ErrEditConflict):
+		return data.ErrEditConflict
+	case errors.Is(err, moviesclient.ErrDuplicate):
+		var apiErr *moviesclient.Error
+		errors.As(err, &apiErr)
+
+		ids := make([]string, len(apiErr.Duplicates))
+		for i, id := range apiErr.Duplicates {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+
+		return fmt.Errorf("a movie with the same year & a similar title exists already (id %s); pass -allow-duplicates to create it anyway",
+			strings.Join(ids, ", "))
+	default:
+		return err
+	}
+}
+
+// Opens the database for the DB backend & the user commands.
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+)
+
+// The columns of the CSV files; an import only needs the title column.
+var csvColumns = []string{"id", "title", "year", "runtime", "genres"}
+
+// A movie of an import file. The runtime may be given as in the API's responses ("102 mins")
+// or as a plain number, so exported files can be imported again.
+type importRecord struct {
+	Title   string          `json:"title"`
+	Year    int32           `json:"year"`
+	Runtime json.RawMessage `json:"runtime"`
+	Genres  []string        `json:"genres"`
+}
+
+func (r importRecord) movie() (*data.Movie, error) {
+	movie := &data.Movie{Title: r.Title, Year: r.Year, Genres: r.Genres}
+
+	if len(r.Runtime) > 0 && string(r.Runtime) != "null" {
+		runtime, err := data.ParseRuntime(strings.Trim(string(r.Runtime), `"`))
+		if err != nil {
+			return nil, err
+		}
+		movie.Runtime = runtime
+	}
+
+	return movie, nil
+}
+
+// Picks the file format from the -format flag, or else from the file extension.
+func fileFormat(format, path string) (string, error) {
+	if format == "" {
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			return "csv", nil
+		}
+		return "json", nil
+	}
+
+	if format != "json" && format != "csv" {
+		return "", usageError{"-format must be either json or csv"}
+	}
+
+	return format, nil
+}
+
+// Imports the movies of a file. All of them are validated before the first one is created,
+// so a file with mistakes doesn't end up half imported (unless -skip-invalid is given).
+func (app *app) importMovies(args []string) error {
+	fs := flag.NewFlagSet("movies import", flag.ContinueOnError)
+
+	format := fs.String("format", "", "File format (json|csv); by default from the file extension")
+	dryRun := fs.Bool("dry-run", false, "Only validate the file")
+	skipInvalid := fs.Bool("skip-invalid", false, "Import the valid movies & report the invalid ones")
+	allowDuplicates := fs.Bool("allow-duplicates", false, "Import movies even if they look like existing ones")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return usageError{"movies import: expected exactly one FILE argument"}
+	}
+	path := fs.Arg(0)
+
+	*format, err = fileFormat(*format, path)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var records []importRecord
+	if *format == "csv" {
+		records, err = readCSV(content)
+	} else {
+		records, err = readJSON(content)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	taxonomy, err := app.backend.Taxonomy()
+	if err != nil {
+		return err
+	}
+
+	var movies []*data.Movie
+	var problems []string
+
+	for i, record := range records {
+		movie, err := record.movie()
+		if err == nil {
+			err = app.validateMovie(movie, taxonomy)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("movie %d (%q): %s", i+1, record.Title, err))
+			continue
+		}
+
+		movies = append(movies, movie)
+	}
+
+	if len(problems) > 0 {
+		if !*skipInvalid {
+			return fmt.Errorf("%d of %d movies are invalid, nothing was imported:\n  %s",
+				len(problems), len(records), strings.Join(problems, "\n  "))
+		}
+
+		for _, problem := range problems {
+			fmt.Fprintln(app.stderr, "skipping", problem)
+		}
+	}
+
+	if *dryRun {
+		return app.printMessage(fmt.Sprintf("%d movies would be imported, %d skipped (dry run)", len(movies), len(problems)))
+	}
+
+	for i, movie := range movies {
+		err = app.backend.CreateMovie(movie, *allowDuplicates)
+		if err != nil {
+			return fmt.Errorf("importing %q failed after %d movies were imported: %w", movie.Title, i, err)
+		}
+	}
+
+	return app.printMessage(fmt.Sprintf("%d movies imported, %d skipped", len(movies), len(problems)))
+}
+
+// Accepts an array of movies as well as the {"movies": [...]} envelope of an export or the API.
+func readJSON(content []byte) ([]importRecord, error) {
+	var records []importRecord
+
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+		err := json.Unmarshal(content, &records)
+		return records, err
+	}
+
+	var envelope struct {
+		Movies []importRecord `json:"movies"`
+	}
+
+	err := json.Unmarshal(content, &envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	if envelope.Movies == nil {
+		return nil, errors.New(`expected an array of movies or a "movies" key`)
+	}
+
+	return envelope.Movies, nil
+}
+
+// The first row names the columns (in any order); the genres are separated by commas.
+func readCSV(content []byte) ([]importRecord, error) {
+	rows, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("missing the header row")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(csvColumns, name) {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New(`missing the "title" column`)
+	}
+
+	records := make([]importRecord, 0, len(rows)-1)
+
+	for n, row := range rows[1:] {
+		cell := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		record := importRecord{Title: cell("title")}
+
+		if s := cell("year"); s != "" {
+			year, err := strconv.ParseInt(s, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid year %q", n+2, s)
+			}
+			record.Year = int32(year)
+		}
+
+		if s := cell("runtime"); s != "" {
+			record.Runtime = json.RawMessage(strconv.Quote(s))
+		}
+
+		if s := cell("genres"); s != "" {
+			record.Genres = strings.Split(s, ",")
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// Writes all the movies, page by page, to a file or to stdout.
+func (app *app) exportMovies(args []string) error {
+	fs := flag.NewFlagSet("movies export", flag.ContinueOnError)
+
+	format := fs.String("format", "", "File format (json|csv); by default from the file extension")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 1 {
+		return usageError{"movies export: expected at most one FILE argument"}
+	}
+	path := fs.Arg(0)
+
+	*format, err = fileFormat(*format, path)
+	if err != nil {
+		return err
+	}
+
+	var movies []*data.Movie
+	filters := data.Filters{Sort: "id", SortSafelist: movieSortSafelist, Page: 1, PageSize: 100}
+
+	for {
+		page, metadata, err := app.backend.ListMovies(data.MovieSearch{}, filters)
+		if err != nil {
+			return err
+		}
+
+		movies = append(movies, page...)
+
+		if len(page) == 0 || filters.Page >= metadata.LastPage {
+			break
+		}
+		filters.Page++
+	}
+
+	if movies == nil {
+		movies = []*data.Movie{}
+	}
+
+	if path == "" || path == "-" {
+		return writeMovies(app.stdout, *format, movies)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = writeMovies(f, *format, movies)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return app.printMessage(fmt.Sprintf("%d movies exported to %s", len(movies), path))
+}
+
+func writeMovies(w io.Writer, format string, movies []*data.Movie) error {
+	if format == "json" {
+		js, err := json.MarshalIndent(map[string]any{"movies": movies}, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "%s\n", js)
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(csvColumns)
+
+	for _, movie := range movies {
+		cw.Write([]string{
+			strconv.FormatInt(movie.ID, 10),
+			movie.Title,
+			strconv.Itoa(int(movie.Year)),
+			strconv.Itoa(int(movie.Runtime)),
+			strings.Join(movie.Genres, ","),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
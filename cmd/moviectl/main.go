@@ -0,0 +1,196 @@
+// moviectl is the command-line tool for operating the movies API: managing movies (through the
+// API or directly in the database), bulk imports & exports, users and permissions.
+//
+// Run "moviectl help" for the list of commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/pkg/moviesclient"
+
+	// Import the pq driver so that it can register itself with *database/sql* package.
+	_ "github.com/lib/pq"
+)
+
+const usage = `Usage: moviectl [flags] <command> [arguments]
+
+Movies (through the API with -api, or directly in the database with -db-dsn):
+  movies list [-title T] [-fuzzy] [-genres a,b] [-any-genre] [-person ID]
+              [-year-min N] [-year-max N] [-runtime-min N] [-runtime-max N]
+              [-sort S] [-page N] [-page-size N]
+  movies search TITLE              typo-tolerant title search, best matches first
+  movies get ID
+  movies create -title T -year N -runtime MINS -genres a,b [-allow-duplicates]
+  movies update [-title T] [-year N] [-runtime MINS] [-genres a,b] [-version N] ID
+  movies delete ID
+  movies import [-format json|csv] [-dry-run] [-skip-invalid] [-allow-duplicates] FILE
+  movies export [-format json|csv] [FILE]
+
+Users & permissions (database only, with -db-dsn):
+  users list
+  users create -name N -email E -password P
+  users delete EMAIL
+  permissions list [EMAIL]         all permission codes, or those of the user
+  permissions grant EMAIL CODE...
+  permissions revoke EMAIL CODE...
+
+Flags:
+`
+
+// Global settings & the backends shared by the commands.
+type app struct {
+	output  string // "table" or "json"
+	stdout  io.Writer
+	stderr  io.Writer
+	backend backend      // for the movie commands
+	models  *data.Models // for the user commands; nil without -db-dsn
+}
+
+// A command failed because of how it was invoked; usage errors exit with status 2.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "moviectl:", err)
+	}
+
+	os.Exit(exitCode(err))
+}
+
+// 0 on success, 2 for usage errors & 1 for any other failure.
+func exitCode(err error) int {
+	var uerr usageError
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &uerr):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Runs the command line (without the program name); the results are printed to stdout.
+func run(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("moviectl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	apiURL := fs.String("api", os.Getenv("MOVIES_API_URL"), "Base URL of the API, e.g. http://localhost:4000")
+	token := fs.String("token", os.Getenv("MOVIES_API_TOKEN"), "Bearer token for the API (changing movies needs the movies:write permission)")
+	dsn := fs.String("db-dsn", os.Getenv("MOVIESDB_DSN"), "PostgreSQL DSN (used for movies when -api isn't set)")
+	timeout := fs.Duration("timeout", 30*time.Second, "Timeout of each API request")
+	output := fs.String("output", "table", "Output format (table|json)")
+
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return usageError{err.Error()}
+	}
+
+	if !slices.Contains([]string{"table", "json"}, *output) {
+		return usageError{"-output must be either table or json"}
+	}
+
+	if fs.NArg() == 0 || fs.Arg(0) == "help" {
+		fs.Usage()
+		return nil
+	}
+
+	app := &app{output: *output, stdout: stdout, stderr: stderr}
+
+	if *dsn != "" {
+		db, err := openDB(*dsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		// No movie cache: moviectl is short-lived & must see the current data.
+		models := data.NewModels(db, nil)
+		app.models = &models
+		app.backend = dbBackend{models: models}
+	}
+
+	// The API takes precedence for movies, so changes go through its validation, history & cache.
+	if *apiURL != "" {
+		app.backend = apiBackend{
+			client:  moviesclient.New(*apiURL, moviesclient.WithToken(*token)),
+			timeout: *timeout,
+		}
+	}
+
+	command, rest := fs.Arg(0), fs.Args()[1:]
+
+	switch command {
+	case "movies":
+		return app.moviesCommand(rest)
+	case "users":
+		return app.usersCommand(rest)
+	case "permissions":
+		return app.permissionsCommand(rest)
+	default:
+		return usageError{fmt.Sprintf("unknown command %q (see moviectl help)", command)}
+	}
+}
+
+// Splits "movies get 1" style arguments into the subcommand & its arguments.
+func subcommand(args []string, group string, names ...string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, usageError{fmt.Sprintf("%s: missing subcommand (%s)", group, strings.Join(names, ", "))}
+	}
+
+	if !slices.Contains(names, args[0]) {
+		return "", nil, usageError{fmt.Sprintf("%s: unknown subcommand %q (%s)", group, args[0], strings.Join(names, ", "))}
+	}
+
+	return args[0], args[1:], nil
+}
+
+// Parses the flags of a subcommand; the positional arguments must follow the flags.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return usageError{fmt.Sprintf("%s: %s", fs.Name(), err)}
+	}
+
+	return nil
+}
+
+func (app *app) requireBackend() error {
+	if app.backend == nil {
+		return usageError{"either -api or -db-dsn is required"}
+	}
+	return nil
+}
+
+func (app *app) requireDB() error {
+	if app.models == nil {
+		return usageError{"this command needs direct database access (-db-dsn)"}
+	}
+	return nil
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A minimal in-memory movies API, recording the requests it receives.
+type fakeAPI struct {
+	mu       sync.Mutex
+	movies   map[int64]string // the JSON of each movie
+	nextID   int64
+	conflict bool // whether updates fail with an edit conflict
+	requests []string
+	bodies   []string
+}
+
+func newFakeAPI(t *testing.T) (*fakeAPI, string) {
+	api := &fakeAPI{
+		movies: map[int64]string{
+			7: `{"id": 7, "title": "Heat", "year": 1995, "runtime": "170 mins", "genres": ["crime", "drama"], "version": 3, "average_rating": 4.5, "rating_count": 2}`,
+			8: `{"id": 8, "title": "Casablanca", "year": 1942, "runtime": "102 mins", "genres": ["drama"], "version": 1, "average_rating": 0, "rating_count": 0}`,
+		},
+		nextID: 9,
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/genres", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"genres": [
+			{"slug": "crime", "name": "Crime", "aliases": []},
+			{"slug": "drama", "name": "Drama", "aliases": ["dramatic"]}
+		]}`)
+	})
+
+	mux.HandleFunc("GET /v1/movies", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+
+		fmt.Fprintf(w, `{"movies": [%s, %s], "metadata": {"current_page": 1, "page_size": 20, "first_page": 1, "last_page": 1, "total_records": 2}}`, api.movies[7], api.movies[8])
+	})
+
+	mux.HandleFunc("GET /v1/movies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+
+		var id int64
+		fmt.Sscan(r.PathValue("id"), &id)
+
+		movie, ok := api.movies[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error": "The requested resource could not be found."}`)
+			return
+		}
+
+		fmt.Fprintf(w, `{"movie": %s}`, movie)
+	})
+
+	mux.HandleFunc("POST /v1/movies", func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+
+		var input map[string]any
+		json.NewDecoder(r.Body).Decode(&input)
+
+		// Movies with the same title & year are refused, unless the client insists.
+		if r.URL.Query().Get("allow_duplicate") != "true" {
+			for id, js := range api.movies {
+				var movie map[string]any
+				json.Unmarshal([]byte(js), &movie)
+
+				if movie["title"] == input["title"] && movie["year"] == input["year"] {
+					w.Header().Set("Link", fmt.Sprintf(`</v1/movies/%d>; rel="duplicate"`, id))
+					w.WriteHeader(http.StatusConflict)
+					fmt.Fprintf(w, `{"error": "A movie with the same title & year exists already.", "duplicates": [{"id": %d}]}`, id)
+					return
+				}
+			}
+		}
+
+		input["id"] = api.nextID
+		input["version"] = 1
+		input["runtime"] = fmt.Sprintf("%v mins", input["runtime"])
+		js, _ := json.Marshal(input)
+
+		api.movies[api.nextID] = string(js)
+		api.nextID++
+
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"movie": %s}`, js)
+	})
+
+	mux.HandleFunc("PATCH /v1/movies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if api.conflict {
+			w.WriteHeader(http.StatusConflict)
+			io.WriteString(w, `{"error": "Unable to update the record due to an edit conflict, please try again."}`)
+			return
+		}
+
+		io.WriteString(w, `{"movie": {"id": 7, "title": "Heat", "year": 1995, "runtime": "100 mins", "genres": ["crime", "drama"], "version": 4}}`)
+	})
+
+	mux.HandleFunc("DELETE /v1/movies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"message": "movie successfully deleted"}`)
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		api.mu.Lock()
+		request := r.Method + " " + r.URL.Path
+		if r.URL.RawQuery != "" {
+			request += "?" + r.URL.RawQuery
+		}
+		if version := r.Header.Get("X-Expected-Version"); version != "" {
+			request += " (version " + version + ")"
+		}
+		api.requests = append(api.requests, request)
+		api.bodies = append(api.bodies, string(body))
+		api.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return api, srv.URL
+}
+
+// The requests other than the genre lookups.
+func (api *fakeAPI) movieRequests() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	var requests []string
+	for _, request := range api.requests {
+		if !strings.HasPrefix(request, "GET /v1/genres") {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+// Runs moviectl (without any settings from the environment) & returns its output & exit code.
+func runMoviectl(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+
+	for _, env := range []string{"MOVIES_API_URL", "MOVIES_API_TOKEN", "MOVIESDB_DSN"} {
+		t.Setenv(env, "")
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	err := run(args, &stdout, &stderr)
+	if err != nil {
+		fmt.Fprintln(&stderr, "moviectl:", err)
+	}
+
+	return stdout.String(), stderr.String(), exitCode(err)
+}
+
+func TestUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{name: "no command", args: nil, code: 0, stderr: "Usage: moviectl"},
+		{name: "help", args: []string{"help"}, code: 0, stderr: "Usage: moviectl"},
+		{name: "-h", args: []string{"-h"}, code: 0, stderr: "Usage: moviectl"},
+		{name: "unknown flag", args: []string{"-verbose", "movies", "list"}, code: 2, stderr: "flag provided but not defined: -verbose"},
+		{name: "bad output", args: []string{"-output", "yaml", "movies", "list"}, code: 2, stderr: "-output must be either table or json"},
+		{name: "bad timeout", args: []string{"-timeout", "soon", "movies", "list"}, code: 2, stderr: `invalid value "soon" for flag -timeout`},
+		{name: "unknown command", args: []string{"film", "list"}, code: 2, stderr: `unknown command "film"`},
+		{name: "missing subcommand", args: []string{"movies"}, code: 2, stderr: "movies: missing subcommand"},
+		{name: "unknown subcommand", args: []string{"movies", "rename"}, code: 2, stderr: `movies: unknown subcommand "rename"`},
+		{name: "no backend", args: []string{"movies", "get", "7"}, code: 2, stderr: "either -api or -db-dsn is required"},
+		{name: "users without db", args: []string{"-api", "http://localhost:4000", "users", "list"}, code: 2, stderr: "needs direct database access"},
+		{name: "permissions without db", args: []string{"permissions", "list"}, code: 2, stderr: "needs direct database access"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr, code := runMoviectl(t, tt.args...)
+
+			if code != tt.code {
+				t.Errorf("exit code = %d; want %d (%s)", code, tt.code, stderr)
+			}
+
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr = %q; want it to contain %q", stderr, tt.stderr)
+			}
+		})
+	}
+}
+
+func TestMoviesCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		conflict bool
+		code     int
+		stdout   string   // the exact output, if code is 0
+		stderr   string   // part of the error, otherwise
+		requests []string // the movie requests sent to the API
+		body     string   // the body of the last one
+	}{
+		{
+			name: "get",
+			args: []string{"movies", "get", "7"},
+			stdout: "ID  TITLE  YEAR  RUNTIME   GENRES       RATING   VERSION\n" +
+				"7   Heat   1995  170 mins  crime,drama  4.5 (2)  3\n",
+			requests: []string{"GET /v1/movies/7"},
+		},
+		{
+			name:     "get missing",
+			args:     []string{"movies", "get", "99"},
+			code:     1,
+			stderr:   "movie 99 not found",
+			requests: []string{"GET /v1/movies/99"},
+		},
+		{
+			name:   "get bad id",
+			args:   []string{"movies", "get", "seven"},
+			code:   2,
+			stderr: `movies get: invalid id "seven"`,
+		},
+		{
+			name:   "get extra args",
+			args:   []string{"movies", "get", "7", "8"},
+			code:   2,
+			stderr: "movies get: expected exactly one ID argument",
+		},
+		{
+			name: "list",
+			args: []string{"movies", "list", "-genres", "Dramatic", "-year-min", "1940", "-runtime-max", "180 mins", "-sort", "-year"},
+			stdout: "ID  TITLE       YEAR  RUNTIME   GENRES       RATING   VERSION\n" +
+				"7   Heat        1995  170 mins  crime,drama  4.5 (2)  3\n" +
+				"8   Casablanca  1942  102 mins  drama        -        1\n" +
+				"\npage 1 of 1 (2 movies)\n",
+			// The genre alias is resolved before the request.
+			requests: []string{"GET /v1/movies?genres=drama&page=1&page_size=20&runtime_max=180&sort=-year&year_min=1940"},
+		},
+		{
+			name:   "list bad sort",
+			args:   []string{"movies", "list", "-sort", "rating"},
+			code:   1,
+			stderr: "validation failed: sort invalid sort value",
+		},
+		{
+			name:   "list bad runtime",
+			args:   []string{"movies", "list", "-runtime-min", "long"},
+			code:   2,
+			stderr: "invalid runtime format",
+		},
+		{
+			name:     "search",
+			args:     []string{"movies", "search", "heet"},
+			stdout:   "",
+			requests: []string{"GET /v1/movies?match=fuzzy&page=1&page_size=20&sort=relevance&title=heet"},
+		},
+		{
+			name: "create",
+			args: []string{"movies", "create", "-title", "Ronin", "-year", "1998", "-runtime", "122", "-genres", "crime"},
+			stdout: "ID  TITLE  YEAR  RUNTIME   GENRES  RATING  VERSION\n" +
+				"9   Ronin  1998  122 mins  crime   -       1\n",
+			requests: []string{"POST /v1/movies"},
+			body:     `{"title":"Ronin","year":1998,"runtime":122,"genres":["crime"]}`,
+		},
+		{
+			name:     "create duplicate",
+			args:     []string{"movies", "create", "-title", "Heat", "-year", "1995", "-runtime", "170", "-genres", "crime"},
+			code:     1,
+			stderr:   "a movie with the same year & a similar title exists already (id 7); pass -allow-duplicates to create it anyway",
+			requests: []string{"POST /v1/movies"},
+		},
+		{
+			name: "create allowed duplicate",
+			args: []string{"movies", "create", "-allow-duplicates", "-title", "Heat", "-year", "1995", "-runtime", "170", "-genres", "crime"},
+			stdout: "ID  TITLE  YEAR  RUNTIME   GENRES  RATING  VERSION\n" +
+				"9   Heat   1995  170 mins  crime   -       1\n",
+			requests: []string{"POST /v1/movies?allow_duplicate=true"},
+			body:     `{"title":"Heat","year":1995,"runtime":170,"genres":["crime"]}`,
+		},
+		{
+			// Invalid movies aren't sent at all.
+			name:   "create invalid",
+			args:   []string{"movies", "create", "-title", "Ronin", "-year", "1998", "-runtime", "122", "-genres", "heist"},
+			code:   1,
+			stderr: "validation failed: genres",
+		},
+		{
+			name: "update",
+			args: []string{"movies", "update", "-runtime", "100", "7"},
+			stdout: "ID  TITLE  YEAR  RUNTIME   GENRES       RATING  VERSION\n" +
+				"7   Heat   1995  100 mins  crime,drama  -       4\n",
+			requests: []string{"GET /v1/movies/7", "PATCH /v1/movies/7 (version 3)"},
+			body:     `{"title":"Heat","year":1995,"runtime":100,"genres":["crime","drama"]}`,
+		},
+		{
+			name:     "update stale version",
+			args:     []string{"movies", "update", "-version", "2", "-runtime", "100", "7"},
+			code:     1,
+			stderr:   "movie 7 is at version 3, not 2",
+			requests: []string{"GET /v1/movies/7"},
+		},
+		{
+			name:     "update conflict",
+			args:     []string{"movies", "update", "-runtime", "100", "7"},
+			conflict: true,
+			code:     1,
+			stderr:   "movie 7 was changed by someone else meanwhile",
+			requests: []string{"GET /v1/movies/7", "PATCH /v1/movies/7 (version 3)"},
+		},
+		{
+			// The flags must come before the id.
+			name:   "update flags after id",
+			args:   []string{"movies", "update", "7", "-runtime", "100"},
+			code:   2,
+			stderr: "movies update: expected exactly one ID argument",
+		},
+		{
+			name:     "delete",
+			args:     []string{"movies", "delete", "8"},
+			stdout:   "movie 8 deleted\n",
+			requests: []string{"DELETE /v1/movies/8"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, url := newFakeAPI(t)
+			api.conflict = tt.conflict
+
+			stdout, stderr, code := runMoviectl(t, append([]string{"-api", url}, tt.args...)...)
+
+			if code != tt.code {
+				t.Fatalf("exit code = %d; want %d (%s)", code, tt.code, stderr)
+			}
+
+			if tt.code == 0 && tt.stdout != "" && stdout != tt.stdout {
+				t.Errorf("stdout:\n%s\nwant:\n%s", stdout, tt.stdout)
+			}
+
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr = %q; want it to contain %q", stderr, tt.stderr)
+			}
+
+			if got := api.movieRequests(); strings.Join(got, "\n") != strings.Join(tt.requests, "\n") {
+				t.Errorf("requests = %q; want %q", got, tt.requests)
+			}
+
+			if tt.body != "" && api.bodies[len(api.bodies)-1] != tt.body {
+				t.Errorf("request body = %s; want %s", api.bodies[len(api.bodies)-1], tt.body)
+			}
+		})
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	_, url := newFakeAPI(t)
+
+	stdout, stderr, code := runMoviectl(t, "-api", url, "-output", "json", "movies", "list")
+	if code != 0 {
+		t.Fatalf("exit code = %d (%s)", code, stderr)
+	}
+
+	// The same envelope as the API's.
+	var body struct {
+		Movies []struct {
+			ID      int64  `json:"id"`
+			Title   string `json:"title"`
+			Runtime string `json:"runtime"`
+		} `json:"movies"`
+		Metadata struct {
+			TotalRecords int `json:"total_records"`
+		} `json:"metadata"`
+	}
+
+	err := json.Unmarshal([]byte(stdout), &body)
+	if err != nil {
+		t.Fatalf("%v: %s", err, stdout)
+	}
+
+	if len(body.Movies) != 2 || body.Movies[0].Title != "Heat" || body.Movies[0].Runtime != "170 mins" || body.Metadata.TotalRecords != 2 {
+		t.Errorf("got %+v", body)
+	}
+
+	stdout, _, _ = runMoviectl(t, "-api", url, "-output", "json", "movies", "delete", "8")
+	if strings.TrimSpace(stdout) != "{\n\t\"message\": \"movie 8 deleted\"\n}" {
+		t.Errorf("delete printed %q", stdout)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	valid := write("valid.csv", "title,year,runtime,genres\nRonin,1998,122,crime\nAlien,1979,117 mins,\"Crime,dramatic\"\n")
+	duplicates := write("duplicates.json", `[{"title": "Ronin", "year": 1998, "runtime": 122, "genres": ["crime"]}, {"title": "Heat", "year": 1995, "runtime": 170, "genres": ["crime"]}]`)
+	invalid := write("invalid.json", `[{"title": "Ronin", "year": 1998, "runtime": 122, "genres": ["crime"]}, {"title": "", "year": 1998, "runtime": "122 mins", "genres": ["crime"]}]`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		code     int
+		stdout   string
+		stderr   string
+		requests []string
+	}{
+		{
+			name:     "csv",
+			args:     []string{"movies", "import", valid},
+			stdout:   "2 movies imported, 0 skipped\n",
+			requests: []string{"POST /v1/movies", "POST /v1/movies"},
+		},
+		{
+			name:   "dry run",
+			args:   []string{"movies", "import", "-dry-run", valid},
+			stdout: "2 movies would be imported, 0 skipped (dry run)\n",
+		},
+		{
+			// Nothing is imported if any movie is invalid...
+			name:   "invalid",
+			args:   []string{"movies", "import", invalid},
+			code:   1,
+			stderr: "1 of 2 movies are invalid, nothing was imported:\n  movie 2 (\"\"): validation failed: title must be provided",
+		},
+		{
+			// ... unless those may be skipped.
+			name:     "skip invalid",
+			args:     []string{"movies", "import", "-skip-invalid", invalid},
+			stdout:   "1 movies imported, 1 skipped\n",
+			stderr:   "skipping movie 2",
+			requests: []string{"POST /v1/movies"},
+		},
+		{
+			// Movies like existing ones stop the import...
+			name:     "duplicate",
+			args:     []string{"movies", "import", duplicates},
+			code:     1,
+			stderr:   `importing "Heat" failed after 1 movies were imported: a movie with the same year & a similar title exists already (id 7)`,
+			requests: []string{"POST /v1/movies", "POST /v1/movies"},
+		},
+		{
+			// ... unless they're wanted.
+			name:     "allow duplicates",
+			args:     []string{"movies", "import", "-allow-duplicates", duplicates},
+			stdout:   "2 movies imported, 0 skipped\n",
+			requests: []string{"POST /v1/movies?allow_duplicate=true", "POST /v1/movies?allow_duplicate=true"},
+		},
+		{
+			name:   "bad format",
+			args:   []string{"movies", "import", "-format", "xml", valid},
+			code:   2,
+			stderr: "-format must be either json or csv",
+		},
+		{
+			name:   "missing file",
+			args:   []string{"movies", "import", filepath.Join(dir, "missing.json")},
+			code:   1,
+			stderr: "no such file or directory",
+		},
+		{
+			name:     "export",
+			args:     []string{"movies", "export", "-format", "csv"},
+			stdout:   "id,title,year,runtime,genres\n7,Heat,1995,170,\"crime,drama\"\n8,Casablanca,1942,102,drama\n",
+			requests: []string{"GET /v1/movies?page=1&page_size=100&sort=id"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, url := newFakeAPI(t)
+
+			stdout, stderr, code := runMoviectl(t, append([]string{"-api", url}, tt.args...)...)
+
+			if code != tt.code {
+				t.Fatalf("exit code = %d; want %d (%s)", code, tt.code, stderr)
+			}
+
+			if stdout != tt.stdout {
+				t.Errorf("stdout = %q; want %q", stdout, tt.stdout)
+			}
+
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr = %q; want it to contain %q", stderr, tt.stderr)
+			}
+
+			if got := api.movieRequests(); strings.Join(got, "\n") != strings.Join(tt.requests, "\n") {
+				t.Errorf("requests = %q; want %q", got, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+)
+
+// The same sort values "GET /v1/movies" accepts.
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "relevance", "-id", "-title", "-year", "-runtime"}
+
+// The failed checks of a validator, as reported by the API.
+type validationError map[string]string
+
+func (e validationError) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = field + " " + e[field]
+	}
+
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+func (app *app) moviesCommand(args []string) error {
+	name, args, err := subcommand(args, "movies", "list", "search", "get", "create", "update", "delete", "import", "export")
+	if err != nil {
+		return err
+	}
+
+	err = app.requireBackend()
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "list":
+		return app.listMovies(args)
+	case "search":
+		return app.searchMovies(args)
+	case "get":
+		return app.getMovie(args)
+	case "create":
+		return app.createMovie(args)
+	case "update":
+		return app.updateMovie(args)
+	case "delete":
+		return app.deleteMovie(args)
+	case "import":
+		return app.importMovies(args)
+	default:
+		return app.exportMovies(args)
+	}
+}
+
+// Normalizes the genres & runs the same checks as the API.
+func (app *app) validateMovie(movie *data.Movie, taxonomy *data.GenreTaxonomy) error {
+	movie.Genres = taxonomy.Normalize(movie.Genres)
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie, taxonomy); !v.Valid() {
+		return validationError(v.Errors)
+	}
+
+	return nil
+}
+
+func (app *app) listMovies(args []string) error {
+	fs := flag.NewFlagSet("movies list", flag.ContinueOnError)
+
+	var search data.MovieSearch
+	var genres, runtimeMin, runtimeMax string
+	filters := data.Filters{SortSafelist: movieSortSafelist}
+
+	fs.StringVar(&search.Title, "title", "", "Search the titles")
+	fs.BoolVar(&search.Fuzzy, "fuzzy", false, "Typo-tolerant title matching")
+	fs.StringVar(&genres, "genres", "", "Comma-separated genres")
+	fs.BoolVar(&search.AnyGenre, "any-genre", false, "Match any of the genres instead of all")
+	fs.Int64Var(&search.PersonID, "person", 0, "Only movies crediting this person")
+	fs.IntVar(&search.YearMin, "year-min", 0, "Minimum release year")
+	fs.IntVar(&search.YearMax, "year-max", 0, "Maximum release year")
+	fs.StringVar(&runtimeMin, "runtime-min", "", "Minimum runtime, e.g. 90")
+	fs.StringVar(&runtimeMax, "runtime-max", "", "Maximum runtime, e.g. 120")
+	fs.StringVar(&filters.Sort, "sort", "id", "Sort order, e.g. title or -year")
+	fs.IntVar(&filters.Page, "page", 1, "Page number")
+	fs.IntVar(&filters.PageSize, "page-size", 20, "Movies per page (max 100)")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if genres != "" {
+		search.Genres = strings.Split(genres, ",")
+	}
+
+	for _, r := range []struct {
+		s   string
+		dst *data.Runtime
+	}{{runtimeMin, &search.RuntimeMin}, {runtimeMax, &search.RuntimeMax}} {
+		if r.s != "" {
+			*r.dst, err = data.ParseRuntime(r.s)
+			if err != nil {
+				return usageError{err.Error()}
+			}
+		}
+	}
+
+	return app.printMovieList(search, filters)
+}
+
+func (app *app) searchMovies(args []string) error {
+	if len(args) != 1 {
+		return usageError{"movies search: expected exactly one TITLE argument"}
+	}
+
+	search := data.MovieSearch{Title: args[0], Fuzzy: true}
+	filters := data.Filters{Sort: "relevance", SortSafelist: movieSortSafelist, Page: 1, PageSize: 20}
+
+	return app.printMovieList(search, filters)
+}
+
+func (app *app) printMovieList(search data.MovieSearch, filters data.Filters) error {
+	// pg_trgm's default; the same as the API's.
+	search.FuzzyThreshold = 0.3
+
+	v := validator.New()
+	data.ValidateFilters(v, filters)
+	data.ValidateMovieSearch(v, search)
+	v.Check(filters.Sort != "relevance" || search.Title != "", "sort", "relevance is only available together with a title search")
+	if !v.Valid() {
+		return validationError(v.Errors)
+	}
+
+	taxonomy, err := app.backend.Taxonomy()
+	if err != nil {
+		return err
+	}
+	search.Genres = taxonomy.Normalize(search.Genres)
+
+	movies, metadata, err := app.backend.ListMovies(search, filters)
+	if err != nil {
+		return err
+	}
+
+	return app.printMovies(movies, metadata)
+}
+
+func parseID(args []string, command string) (int64, error) {
+	if len(args) != 1 {
+		return 0, usageError{command + ": expected exactly one ID argument"}
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id < 1 {
+		return 0, usageError{fmt.Sprintf("%s: invalid id %q", command, args[0])}
+	}
+
+	return id, nil
+}
+
+func (app *app) getMovie(args []string) error {
+	id, err := parseID(args, "movies get")
+	if err != nil {
+		return err
+	}
+
+	movie, err := app.backend.GetMovie(id)
+	if err != nil {
+		return movieError(err, id)
+	}
+
+	return app.printMovie(movie)
+}
+
+// The flags shared by create & update.
+type movieFlags struct {
+	title   string
+	year    int
+	runtime string
+	genres  string
+}
+
+func (f *movieFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.title, "title", "", "Title")
+	fs.IntVar(&f.year, "year", 0, "Release year")
+	fs.StringVar(&f.runtime, "runtime", "", `Runtime in minutes, e.g. 102 or "102 mins"`)
+	fs.StringVar(&f.genres, "genres", "", "Comma-separated genres")
+}
+
+// Copies the flags which were given onto the movie.
+func (f *movieFlags) apply(fs *flag.FlagSet, movie *data.Movie) error {
+	var err error
+
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "title":
+			movie.Title = f.title
+		case "year":
+			movie.Year = int32(f.year)
+		case "runtime":
+			var runtime data.Runtime
+			runtime, err = data.ParseRuntime(f.runtime)
+			movie.Runtime = runtime
+		case "genres":
+			movie.Genres = strings.Split(f.genres, ",")
+		}
+	})
+
+	if err != nil {
+		return usageError{err.Error()}
+	}
+
+	return nil
+}
+
+func (app *app) createMovie(args []string) error {
+	fs := flag.NewFlagSet("movies create", flag.ContinueOnError)
+
+	var f movieFlags
+	f.register(fs)
+	allowDuplicate := fs.Bool("allow-duplicates", false, "Create the movie even if it looks like an existing one")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	movie := &data.Movie{}
+
+	err = f.apply(fs, movie)
+	if err != nil {
+		return err
+	}
+
+	taxonomy, err := app.backend.Taxonomy()
+	if err != nil {
+		return err
+	}
+
+	err = app.validateMovie(movie, taxonomy)
+	if err != nil {
+		return err
+	}
+
+	err = app.backend.CreateMovie(movie, *allowDuplicate)
+	if err != nil {
+		return err
+	}
+
+	return app.printMovie(movie)
+}
+
+func (app *app) updateMovie(args []string) error {
+	fs := flag.NewFlagSet("movies update", flag.ContinueOnError)
+
+	var f movieFlags
+	f.register(fs)
+	version := fs.Int("version", 0, "Only update if the movie is still at this version")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	id, err := parseID(fs.Args(), "movies update")
+	if err != nil {
+		return err
+	}
+
+	movie, err := app.backend.GetMovie(id)
+	if err != nil {
+		return movieError(err, id)
+	}
+
+	if *version != 0 && int32(*version) != movie.Version {
+		return fmt.Errorf("movie %d is at version %d, not %d; fetch it again & retry", id, movie.Version, *version)
+	}
+
+	err = f.apply(fs, movie)
+	if err != nil {
+		return err
+	}
+
+	taxonomy, err := app.backend.Taxonomy()
+	if err != nil {
+		return err
+	}
+
+	err = app.validateMovie(movie, taxonomy)
+	if err != nil {
+		return err
+	}
+
+	err = app.backend.UpdateMovie(movie)
+	if err != nil {
+		return movieError(err, id)
+	}
+
+	return app.printMovie(movie)
+}
+
+func (app *app) deleteMovie(args []string) error {
+	id, err := parseID(args, "movies delete")
+	if err != nil {
+		return err
+	}
+
+	err = app.backend.DeleteMovie(id)
+	if err != nil {
+		return movieError(err, id)
+	}
+
+	return app.printMessage(fmt.Sprintf("movie %d deleted", id))
+}
+
+func movieError(err error, id int64) error {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return fmt.Errorf("movie %d not found", id)
+	case errors.Is(err, data.ErrEditConflict):
+		return fmt.Errorf("movie %d was changed by someone else meanwhile; please retry", id)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+)
+
+// With -output json everything is printed in the API's envelopes, so scripts can use both alike.
+func (app *app) printJSON(body any) error {
+	js, err := json.MarshalIndent(body, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(app.stdout, "%s\n", js)
+	return err
+}
+
+// Prints the rows as aligned columns, the first row being the header.
+func (app *app) printTable(rows [][]string) error {
+	tw := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
+
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func movieRow(movie *data.Movie) []string {
+	rating := "-"
+	if movie.RatingCount > 0 {
+		rating = fmt.Sprintf("%.1f (%d)", movie.AverageRating, movie.RatingCount)
+	}
+
+	return []string{
+		fmt.Sprint(movie.ID),
+		movie.Title,
+		fmt.Sprint(movie.Year),
+		fmt.Sprintf("%d mins", movie.Runtime),
+		strings.Join(movie.Genres, ","),
+		rating,
+		fmt.Sprint(movie.Version),
+	}
+}
+
+var movieHeader = []string{"ID", "TITLE", "YEAR", "RUNTIME", "GENRES", "RATING", "VERSION"}
+
+func (app *app) printMovie(movie *data.Movie) error {
+	if app.output == "json" {
+		return app.printJSON(map[string]any{"movie": movie})
+	}
+
+	return app.printTable([][]string{movieHeader, movieRow(movie)})
+}
+
+func (app *app) printMovies(movies []*data.Movie, metadata data.Metadata) error {
+	if app.output == "json" {
+		return app.printJSON(map[string]any{"movies": movies, "metadata": metadata})
+	}
+
+	rows := [][]string{movieHeader}
+	for _, movie := range movies {
+		rows = append(rows, movieRow(movie))
+	}
+
+	err := app.printTable(rows)
+	if err != nil {
+		return err
+	}
+
+	if metadata.TotalRecords > 0 {
+		_, err = fmt.Fprintf(app.stdout, "\npage %d of %d (%d movies)\n", metadata.CurrentPage, metadata.LastPage, metadata.TotalRecords)
+	}
+
+	return err
+}
+
+func (app *app) printUsers(users []*data.User) error {
+	if app.output == "json" {
+		return app.printJSON(map[string]any{"users": users})
+	}
+
+	rows := [][]string{{"ID", "NAME", "EMAIL", "CREATED"}}
+	for _, user := range users {
+		rows = append(rows, []string{fmt.Sprint(user.ID), user.Name, user.Email, user.CreatedAt.Format("2006-01-02 15:04")})
+	}
+
+	return app.printTable(rows)
+}
+
+func (app *app) printPermissions(permissions data.Permissions) error {
+	if app.output == "json" {
+		return app.printJSON(map[string]any{"permissions": permissions})
+	}
+
+	rows := [][]string{{"PERMISSION"}}
+	for _, code := range permissions {
+		rows = append(rows, []string{code})
+	}
+
+	return app.printTable(rows)
+}
+
+// Confirmations of commands without a result, e.g. deletions.
+func (app *app) printMessage(message string) error {
+	if app.output == "json" {
+		return app.printJSON(map[string]any{"message": message})
+	}
+
+	_, err := fmt.Fprintln(app.stdout, message)
+	return err
+}
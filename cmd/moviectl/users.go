@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/heschmat/go_movies_api_rest/internal/data"
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+)
+
+func (app *app) usersCommand(args []string) error {
+	name, args, err := subcommand(args, "users", "list", "create", "delete")
+	if err != nil {
+		return err
+	}
+
+	err = app.requireDB()
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "list":
+		if len(args) != 0 {
+			return usageError{"users list: takes no arguments"}
+		}
+
+		users, err := app.models.Users.GetAll()
+		if err != nil {
+			return err
+		}
+
+		return app.printUsers(users)
+	case "create":
+		return app.createUser(args)
+	default:
+		if len(args) != 1 {
+			return usageError{"users delete: expected exactly one EMAIL argument"}
+		}
+
+		user, err := app.getUser(args[0])
+		if err != nil {
+			return err
+		}
+
+		err = app.models.Users.Delete(user.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.printMessage(fmt.Sprintf("user %s deleted", user.Email))
+	}
+}
+
+func (app *app) createUser(args []string) error {
+	fs := flag.NewFlagSet("users create", flag.ContinueOnError)
+
+	name := fs.String("name", "", "Name")
+	email := fs.String("email", "", "Email address")
+	password := fs.String("password", "", "Password (8 to 72 bytes)")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	user := &data.User{Name: *name, Email: *email}
+
+	err = user.Password.Set(*password)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return validationError(v.Errors)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			return validationError{"email": "a user with this email address already exists"}
+		default:
+			return err
+		}
+	}
+
+	return app.printUsers([]*data.User{user})
+}
+
+func (app *app) getUser(email string) (*data.User, error) {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, fmt.Errorf("no user with the email address %s", email)
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (app *app) permissionsCommand(args []string) error {
+	name, args, err := subcommand(args, "permissions", "list", "grant", "revoke")
+	if err != nil {
+		return err
+	}
+
+	err = app.requireDB()
+	if err != nil {
+		return err
+	}
+
+	if name == "list" {
+		switch len(args) {
+		case 0:
+			permissions, err := app.models.Permissions.GetAll()
+			if err != nil {
+				return err
+			}
+
+			return app.printPermissions(permissions)
+		case 1:
+			user, err := app.getUser(args[0])
+			if err != nil {
+				return err
+			}
+
+			permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				return err
+			}
+
+			return app.printPermissions(permissions)
+		default:
+			return usageError{"permissions list: expected at most one EMAIL argument"}
+		}
+	}
+
+	if len(args) < 2 {
+		return usageError{fmt.Sprintf("permissions %s: expected an EMAIL & at least one permission code", name)}
+	}
+
+	user, err := app.getUser(args[0])
+	if err != nil {
+		return err
+	}
+	codes := args[1:]
+
+	// Unknown codes would be ignored silently by the queries; report the typos instead.
+	all, err := app.models.Permissions.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if !all.Include(code) {
+			return usageError{fmt.Sprintf("unknown permission %q (one of %s)", code, strings.Join(all, ", "))}
+		}
+	}
+
+	if name == "grant" {
+		err = app.models.Permissions.AddForUser(user.ID, codes...)
+	} else {
+		err = app.models.Permissions.RemoveForUser(user.ID, codes...)
+	}
+	if err != nil {
+		return err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.printPermissions(permissions)
+}
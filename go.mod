@@ -5,3 +5,7 @@ go 1.23.5
 require github.com/julienschmidt/httprouter v1.3.0
 
 require github.com/lib/pq v1.10.9
+
+require golang.org/x/crypto v0.32.0
+
+require golang.org/x/image v0.25.0
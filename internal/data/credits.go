@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+)
+
+// The roles a person can be credited for on a movie.
+const (
+	RoleDirector = "director"
+	RoleWriter   = "writer"
+	RoleActor    = "actor"
+)
+
+// Links a person to a movie.
+// Character & billing order only apply to actors (billing order 0 means "unbilled").
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	PersonID     int64  `json:"person_id"`
+	PersonName   string `json:"person_name"` // read-only; joined from the people table
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order,omitempty"`
+}
+
+type CreditModel struct {
+	DB *sql.DB
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.PermittedValue(credit.Role, RoleDirector, RoleWriter, RoleActor), "role", "must be one of director, writer or actor")
+
+	if credit.Role == RoleActor {
+		v.Check(credit.Character != "", "character", "must be provided for actors")
+		v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+		v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+	} else {
+		v.Check(credit.Character == "", "character", "is only allowed for actors")
+		v.Check(credit.BillingOrder == 0, "billing_order", "is only allowed for actors")
+	}
+}
+
+func (m CreditModel) Insert(credit *Credit) error {
+	// Insert & read back the person's name in one round trip.
+	q := `WITH c AS (
+		INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, person_id
+	)
+	SELECT c.id, people.name FROM c INNER JOIN people ON people.id = c.person_id`
+
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, q, args...).Scan(&credit.ID, &credit.PersonName)
+	if err != nil {
+		switch {
+		case violatesConstraint(err, uniqueViolation, "movie_credits_unique_key"):
+			return ErrDuplicateCredit
+		// The person (or movie) does not exist.
+		case violatesConstraint(err, foreignKeyViolation, "movie_credits_person_id_fkey"):
+			return ErrRecordNotFound
+		case violatesConstraint(err, foreignKeyViolation, "movie_credits_movie_id_fkey"):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the credits of a movie: directors first, then writers, then the billed cast.
+func (m CreditModel) GetForMovie(movieID int64) ([]*Credit, error) {
+	q := `SELECT movie_credits.id, movie_id, person_id, people.name, role, character, billing_order
+	FROM movie_credits
+	INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE movie_id = $1
+	ORDER BY CASE role WHEN 'director' THEN 1 WHEN 'writer' THEN 2 ELSE 3 END,
+		NULLIF(billing_order, 0) NULLS LAST, movie_credits.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// Removes a single credit from a movie.
+func (m CreditModel) Delete(movieID, id int64) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	q := "DELETE FROM movie_credits WHERE id = $1 AND movie_id = $2"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, q, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := []byte("secret")
+
+	tests := []cursor{
+		{Sort: "id", Key: "42", ID: 42},
+		{Sort: "-title", Key: "Heat & Dust", ID: 7},
+		{Sort: "relevance", Key: "0.0759", ID: 3, Backward: true},
+	}
+
+	for _, want := range tests {
+		t.Run(want.Sort, func(t *testing.T) {
+			got, err := decodeCursor(want.encode(key), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *got != want {
+				t.Errorf("decoded %+v; want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	key := []byte("secret")
+	valid := cursor{Sort: "year", Key: "1995", ID: 12}.encode(key)
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	// Flips a bit of the decoded part, keeping the base64 valid.
+	flip := func(s string, i int) string {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		b[i] ^= 1
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		key    []byte
+	}{
+		{name: "flipped payload byte", cursor: flip(payload, 10) + "." + signature, key: key},
+		{name: "flipped signature byte", cursor: payload + "." + flip(signature, 0), key: key},
+		{name: "other key", cursor: valid, key: []byte("another secret")},
+		{name: "no separator", cursor: payload + signature, key: key},
+		{name: "bad payload base64", cursor: "!!!." + signature, key: key},
+		{name: "bad signature base64", cursor: payload + ".!!!", key: key},
+		{name: "missing signature", cursor: payload + ".", key: key},
+		{name: "empty", cursor: "", key: key},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor, tt.key)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("err = %v; want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestFiltersParseCursor(t *testing.T) {
+	key := []byte("secret")
+
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+		err    error
+	}{
+		{name: "no cursor", sort: "id"},
+		{name: "same sort", sort: "-year", cursor: cursor{Sort: "-year", Key: "1995", ID: 12}.encode(key)},
+		{name: "other sort", sort: "year", cursor: cursor{Sort: "-year", Key: "1995", ID: 12}.encode(key), err: ErrInvalidCursor},
+		{name: "other column", sort: "title", cursor: cursor{Sort: "year", Key: "1995", ID: 12}.encode(key), err: ErrInvalidCursor},
+		// Signed, but the key doesn't fit the column type.
+		{name: "non-numeric key", sort: "runtime", cursor: cursor{Sort: "runtime", Key: "long", ID: 12}.encode(key), err: ErrInvalidCursor},
+		{name: "tampered", sort: "id", cursor: "e30.AAAA", err: ErrInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{Sort: tt.sort, Cursor: tt.cursor, CursorKey: key}
+
+			err := f.ParseCursor()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v; want %v", err, tt.err)
+			}
+
+			if tt.err != nil || tt.cursor == "" {
+				if f.cursor != nil {
+					t.Errorf("cursor = %+v; want none", *f.cursor)
+				}
+				return
+			}
+
+			if f.cursor == nil || f.cursor.Sort != tt.sort {
+				t.Errorf("cursor = %+v; want one for sort %q", f.cursor, tt.sort)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package data
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidDateFormat = errors.New(`invalid date format; expected "YYYY-MM-DD"`)
+
+// A calendar date (without time of day); encoded in JSON as "2006-01-02".
+type Date time.Time
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Time(d).Format(time.DateOnly))), nil
+}
+
+func (d *Date) UnmarshalJSON(jsonVal []byte) error {
+	unquoted, err := strconv.Unquote(string(jsonVal))
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	t, err := time.Parse(time.DateOnly, unquoted)
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	*d = Date(t)
+	return nil
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"math"
+	"strings"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+)
+
+// The pagination & sorting parameters shared by every list endpoint.
+// A "-" prefix on the sort value means descending order, e.g. "-year".
+type Filters struct {
+	Page         int
+	PageSize     int
+	Sort         string
+	SortSafelist []string // the permitted sort values
+	// Cursor is an opaque keyset pagination cursor (see ParseCursor); it replaces Page when set.
+	// Lists only hand out cursors if a CursorKey (used to sign them) is provided.
+	Cursor    string
+	CursorKey []byte
+	cursor    *cursor
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	v.Check(f.Cursor == "" || f.Page == 1, "page", "must not be combined with a cursor")
+}
+
+// Returns the column name to sort by (without the "-" prefix).
+// The value is checked against the safelist again, since it ends up interpolated into SQL.
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+
+	return "ASC"
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// Pagination information returned alongside the records of a list endpoint.
+// With cursor pagination only the page size & the cursors are known.
+type Metadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+}
+
+// An empty Metadata is returned if there are no records at all.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
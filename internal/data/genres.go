@@ -0,0 +1,520 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+)
+
+// A canonical genre. Movies store the slug; aliases are alternative spellings resolving to it.
+type Genre struct {
+	ID         int64     `json:"-"`
+	CreatedAt  time.Time `json:"-"`
+	Slug       string    `json:"slug"`
+	Name       string    `json:"name"`
+	Aliases    []string  `json:"aliases"`
+	MovieCount int64     `json:"movie_count"` // read-only
+}
+
+// Turns free-form text into a slug, e.g. "Science Fiction" => "science-fiction".
+// N.B. Must stay in sync with the expression used in migration 000008.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	return b.String()
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(genre.Slug == Slugify(genre.Slug), "slug", "must only contain lowercase letters, digits & single dashes")
+
+	for _, alias := range genre.Aliases {
+		v.Check(alias == Slugify(alias) && alias != "", "aliases", "must only contain lowercase letters, digits & single dashes")
+		v.Check(alias != genre.Slug, "aliases", "must not repeat the slug")
+	}
+}
+
+// An in-memory snapshot of the genre taxonomy, used to resolve & validate movie genres.
+type GenreTaxonomy struct {
+	lookup map[string]string // slug or alias => canonical slug
+}
+
+// Builds a taxonomy from a list of genres, e.g. as returned by "GET /v1/genres".
+func NewGenreTaxonomy(genres []*Genre) *GenreTaxonomy {
+	taxonomy := &GenreTaxonomy{lookup: make(map[string]string)}
+
+	for _, genre := range genres {
+		taxonomy.lookup[genre.Slug] = genre.Slug
+		for _, alias := range genre.Aliases {
+			taxonomy.lookup[alias] = genre.Slug
+		}
+	}
+
+	return taxonomy
+}
+
+// Returns the canonical slug for a free-form genre name.
+func (t *GenreTaxonomy) Resolve(name string) (string, bool) {
+	slug, ok := t.lookup[Slugify(name)]
+	return slug, ok
+}
+
+// Replaces every known genre with its canonical slug & removes duplicates.
+// Unknown genres are kept as-is, so that ValidateMovie can report them.
+func (t *GenreTaxonomy) Normalize(genres []string) []string {
+	if genres == nil {
+		return nil
+	}
+
+	normalized := make([]string, 0, len(genres))
+	seen := make(map[string]bool)
+
+	for _, g := range genres {
+		if slug, ok := t.Resolve(g); ok {
+			g = slug
+		}
+
+		if !seen[g] {
+			seen[g] = true
+			normalized = append(normalized, g)
+		}
+	}
+
+	return normalized
+}
+
+// true if the slug is a canonical genre (NOT an alias).
+func (t *GenreTaxonomy) IsCanonical(slug string) bool {
+	canonical, ok := t.lookup[slug]
+	return ok && canonical == slug
+}
+
+// Finds the closest known genre for a misspelled name; "" if nothing is close enough.
+func (t *GenreTaxonomy) Suggest(name string) string {
+	key := Slugify(name)
+	best, bestDistance := "", -1
+
+	for candidate, slug := range t.lookup {
+		d := levenshtein(key, candidate)
+		if bestDistance == -1 || d < bestDistance || (d == bestDistance && slug < best) {
+			best, bestDistance = slug, d
+		}
+	}
+
+	// Allow roughly one typo per 3 characters, but at least 2.
+	if bestDistance == -1 || bestDistance > max(2, len(key)/3) {
+		return ""
+	}
+
+	return best
+}
+
+// The number of single character edits needed to turn a into b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// Checks that every genre is a canonical slug, adding "did you mean" hints for typos.
+func validateGenres(v *validator.Validator, genres []string, taxonomy *GenreTaxonomy) {
+	var problems []string
+
+	for _, g := range genres {
+		if taxonomy.IsCanonical(g) {
+			continue
+		}
+
+		msg := fmt.Sprintf("%q is not a known genre", g)
+		if suggestion := taxonomy.Suggest(g); suggestion != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+
+		problems = append(problems, msg)
+	}
+
+	if len(problems) > 0 {
+		v.AddError("genres", strings.Join(problems, "; "))
+	}
+}
+
+type GenreModel struct {
+	DB         *sql.DB
+	movieCache *MovieCache // renames & merges retag movies
+}
+
+// Loads every genre slug & alias.
+func (m GenreModel) Taxonomy() (*GenreTaxonomy, error) {
+	q := `SELECT slug, slug FROM genres
+	UNION ALL
+	SELECT genre_aliases.alias, genres.slug
+	FROM genre_aliases
+	INNER JOIN genres ON genres.id = genre_aliases.genre_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taxonomy := &GenreTaxonomy{lookup: make(map[string]string)}
+
+	for rows.Next() {
+		var key, slug string
+
+		err := rows.Scan(&key, &slug)
+		if err != nil {
+			return nil, err
+		}
+
+		taxonomy.lookup[key] = slug
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return taxonomy, nil
+}
+
+// Lists all genres (by name) along with the number of movies tagged with each one.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	q := `SELECT genres.id, genres.created_at, genres.slug, genres.name,
+		COALESCE((SELECT array_agg(alias ORDER BY alias) FROM genre_aliases WHERE genre_id = genres.id), '{}'),
+		(SELECT COUNT(*) FROM movies WHERE movies.genres @> ARRAY[genres.slug])
+	FROM genres
+	ORDER BY genres.name, genres.slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&genre.ID,
+			&genre.CreatedAt,
+			&genre.Slug,
+			&genre.Name,
+			pq.Array(&genre.Aliases),
+			&genre.MovieCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+func (m GenreModel) Get(slug string) (*Genre, error) {
+	q := `SELECT genres.id, genres.created_at, genres.slug, genres.name,
+		COALESCE((SELECT array_agg(alias ORDER BY alias) FROM genre_aliases WHERE genre_id = genres.id), '{}'),
+		(SELECT COUNT(*) FROM movies WHERE movies.genres @> ARRAY[genres.slug])
+	FROM genres
+	WHERE slug = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, q, slug).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Slug,
+		&genre.Name,
+		pq.Array(&genre.Aliases),
+		&genre.MovieCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// Adds a new genre together with its aliases.
+// Returns ErrDuplicateGenre if the slug or any alias is already taken.
+func (m GenreModel) Insert(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkGenreKeysFree(ctx, tx, 0, append([]string{genre.Slug}, genre.Aliases...)...)
+	if err != nil {
+		return err
+	}
+
+	q := `INSERT INTO genres (slug, name)
+	VALUES ($1, $2)
+	RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, q, genre.Slug, genre.Name).Scan(&genre.ID, &genre.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range genre.Aliases {
+		_, err = tx.ExecContext(ctx, "INSERT INTO genre_aliases (alias, genre_id) VALUES ($1, $2)", alias, genre.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Renames a genre. When the slug changes, the old slug is kept as an alias
+// & every movie tagged with it is rewritten (recorded as a new movie version).
+func (m GenreModel) Rename(oldSlug string, genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if genre.Slug != oldSlug {
+		// The new slug may be one of the genre's own aliases; that alias is simply promoted.
+		_, err = tx.ExecContext(ctx, "DELETE FROM genre_aliases WHERE alias = $1 AND genre_id = $2", genre.Slug, genre.ID)
+		if err != nil {
+			return err
+		}
+
+		err = checkGenreKeysFree(ctx, tx, genre.ID, genre.Slug)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE genres SET slug = $1, name = $2 WHERE id = $3", genre.Slug, genre.Name, genre.ID)
+	if err != nil {
+		return err
+	}
+
+	// A movie.updated event for each retagged movie.
+	var events []outboxEntry
+
+	if genre.Slug != oldSlug {
+		_, err = tx.ExecContext(ctx, "INSERT INTO genre_aliases (alias, genre_id) VALUES ($1, $2)", oldSlug, genre.ID)
+		if err != nil {
+			return err
+		}
+
+		events, err = replaceMovieGenre(ctx, tx, oldSlug, genre.Slug)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = commitOutbox(tx, events...)
+	if err != nil {
+		return err
+	}
+
+	if genre.Slug != oldSlug {
+		m.movieCache.purge()
+	}
+
+	return nil
+}
+
+// Folds the source genre into the target one: the source slug & aliases become aliases
+// of the target, movies are retagged & the source genre is removed.
+func (m GenreModel) Merge(source, target *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE genre_aliases SET genre_id = $1 WHERE genre_id = $2", target.ID, source.ID)
+	if err != nil {
+		return err
+	}
+
+	events, err := replaceMovieGenre(ctx, tx, source.Slug, target.Slug)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM genres WHERE id = $1", source.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO genre_aliases (alias, genre_id) VALUES ($1, $2)", source.Slug, target.ID)
+	if err != nil {
+		return err
+	}
+
+	err = commitOutbox(tx, events...)
+	if err != nil {
+		return err
+	}
+
+	m.movieCache.purge()
+
+	return nil
+}
+
+// Returns ErrDuplicateGenre if any of the keys is already used as a slug or alias
+// (by a genre other than exceptID).
+func checkGenreKeysFree(ctx context.Context, tx *sql.Tx, exceptID int64, keys ...string) error {
+	q := `SELECT EXISTS (
+		SELECT 1 FROM genres WHERE slug = ANY($1) AND id <> $2
+		UNION ALL
+		SELECT 1 FROM genre_aliases WHERE alias = ANY($1) AND genre_id <> $2
+	)`
+
+	var exists bool
+
+	err := tx.QueryRowContext(ctx, q, pq.Array(keys), exceptID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrDuplicateGenre
+	}
+
+	return nil
+}
+
+// Swaps one genre slug for another on every movie (order preserving, without duplicates).
+// Each affected movie gets a new version, which is also recorded in its history;
+// the returned movie.updated events (one per movie) are to be committed with the transaction.
+func replaceMovieGenre(ctx context.Context, tx *sql.Tx, from, to string) ([]outboxEntry, error) {
+	q := `WITH updated AS (
+		UPDATE movies
+		SET genres = ARRAY(
+			SELECT g FROM unnest(array_replace(genres, $1, $2)) WITH ORDINALITY AS u(g, ord)
+			GROUP BY g
+			ORDER BY min(ord)
+		), version = version + 1
+		WHERE genres @> ARRAY[$1]
+		RETURNING id, version, title, year, runtime, genres
+	), versions AS (
+		INSERT INTO movie_versions (movie_id, version, title, year, runtime, genres)
+		SELECT id, version, title, year, runtime, genres FROM updated
+	)
+	SELECT id FROM updated ORDER BY id`
+
+	rows, err := tx.QueryContext(ctx, q, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The events carry the whole movie (ratings included), like those of MovieModel.Update.
+	events := make([]outboxEntry, len(ids))
+
+	for i, id := range ids {
+		movie, err := getMovie(tx, id, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		events[i], err = newOutboxEntry(EventMovieUpdated, map[string]any{"movie": movie})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
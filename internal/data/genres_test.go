@@ -0,0 +1,64 @@
+package data
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Science Fiction", "science-fiction"},
+		{"sci-fi", "sci-fi"},
+		{"  Film -- Noir!! ", "film-noir"},
+		{"Rock'n'Roll", "rock-n-roll"},
+		{"_Action_/_Adventure_", "action-adventure"},
+		{"Top 100", "top-100"},
+		// Like the expression in migration 000008, only ASCII letters & digits are kept;
+		// anything else separates words.
+		{"Ciencia Ficción", "ciencia-ficci-n"},
+		{"Ästhetik", "sthetik"},
+		{"日本映画", ""},
+		{"", ""},
+		{"---", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Slugify(tt.in); got != tt.want {
+			t.Errorf("Slugify(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGenreTaxonomySuggest(t *testing.T) {
+	taxonomy := NewGenreTaxonomy([]*Genre{
+		{Slug: "crime"},
+		{Slug: "drama"},
+		{Slug: "sci-fi", Aliases: []string{"science-fiction", "scifi"}},
+		{Slug: "short"},
+		{Slug: "sport"},
+		{Slug: "war"},
+		{Slug: "western"},
+	})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Dramma", "drama"},
+		// An alias's typo suggests the canonical slug.
+		{"Sience Fiction", "sci-fi"},
+		{"sifi", "sci-fi"},
+		{"wars", "war"},
+		// One edit away from both "short" & "sport"; ties go to the alphabetically first slug.
+		{"shport", "short"},
+		// Too far from anything.
+		{"documentary", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := taxonomy.Suggest(tt.name); got != tt.want {
+			t.Errorf("Suggest(%q) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
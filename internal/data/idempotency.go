@@ -0,0 +1,151 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// A request made with an Idempotency-Key header & (once it's complete) its response.
+type IdempotencyRecord struct {
+	UserID      int64
+	Key         string
+	Fingerprint []byte
+	// 0 while the request is in flight.
+	StatusCode int
+	Header     map[string][]string
+	Body       []byte
+	ExpiresAt  time.Time
+}
+
+// Whether the record belongs to the same request, i.e. one with the same fingerprint.
+func (rec *IdempotencyRecord) Matches(fingerprint []byte) bool {
+	return bytes.Equal(rec.Fingerprint, fingerprint)
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Claims the key for a new request. If it's taken, the existing record is returned instead
+// (claimed = false): either a completed request or one still in flight.
+//
+// Expired keys & requests abandoned in flight (not completed before lockTimeout) are taken over.
+func (m IdempotencyModel) Claim(userID int64, key string, fingerprint []byte, ttl, lockTimeout time.Duration) (rec *IdempotencyRecord, claimed bool, err error) {
+	q := `INSERT INTO idempotency_keys (user_id, key, fingerprint, locked_until, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (user_id, key) DO UPDATE
+	SET created_at = NOW(), fingerprint = EXCLUDED.fingerprint,
+		status_code = NULL, header = NULL, body = NULL,
+		locked_until = EXCLUDED.locked_until, expires_at = EXCLUDED.expires_at
+	WHERE idempotency_keys.expires_at < NOW()
+		OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until < NOW())
+	RETURNING true`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The existing record may expire (& be pruned) between the two statements; one more try does then.
+	for range 2 {
+		now := time.Now()
+
+		err = m.DB.QueryRowContext(ctx, q, userID, key, fingerprint, now.Add(lockTimeout), now.Add(ttl)).Scan(&claimed)
+		if err == nil {
+			return nil, true, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, false, err
+		}
+
+		rec, err = m.get(ctx, userID, key)
+		if err == nil {
+			return rec, false, nil
+		}
+		if !errors.Is(err, ErrRecordNotFound) {
+			return nil, false, err
+		}
+	}
+
+	return nil, false, errors.New("unable to claim the idempotency key")
+}
+
+func (m IdempotencyModel) get(ctx context.Context, userID int64, key string) (*IdempotencyRecord, error) {
+	q := `SELECT user_id, key, fingerprint, COALESCE(status_code, 0), header, body, expires_at
+	FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`
+
+	var rec IdempotencyRecord
+	var header []byte
+
+	err := m.DB.QueryRowContext(ctx, q, userID, key).Scan(
+		&rec.UserID,
+		&rec.Key,
+		&rec.Fingerprint,
+		&rec.StatusCode,
+		&header,
+		&rec.Body,
+		&rec.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if header != nil {
+		err = json.Unmarshal(header, &rec.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &rec, nil
+}
+
+// Stores the response of the request which claimed the key.
+func (m IdempotencyModel) Complete(rec *IdempotencyRecord) error {
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return err
+	}
+
+	q := `UPDATE idempotency_keys
+	SET status_code = $3, header = $4, body = $5
+	WHERE user_id = $1 AND key = $2 AND status_code IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, q, rec.UserID, rec.Key, rec.StatusCode, header, rec.Body)
+	return err
+}
+
+// Gives up a claimed key without storing a response, so the request can be retried.
+func (m IdempotencyModel) Release(userID int64, key string) error {
+	q := `DELETE FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2 AND status_code IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, q, userID, key)
+	return err
+}
+
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -3,21 +3,63 @@ package data
 import (
 	"database/sql"
 	"errors"
+
+	"github.com/lib/pq"
 )
 
 // We'll use this in the *Get()* method when a movie could not be found.
+// ErrEditConflict is returned when a record changed between reading & updating it.
 var (
 	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("edit conflict")
+)
+
+// Reports whether the error is Postgres rejecting a write for violating the named constraint,
+// e.g. "reviews_movie_user_key"; the code tells the kind of violation, e.g. "23505" (unique_violation).
+func violatesConstraint(err error, code pq.ErrorCode, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == code && pqErr.Constraint == constraint
+}
+
+// The SQLSTATE codes of the constraint violations handled by the models.
+const (
+	uniqueViolation     = pq.ErrorCode("23505")
+	foreignKeyViolation = pq.ErrorCode("23503")
 )
 
 // The *Models* struct acts as single container holding all the db models.
 type Models struct {
-	Movies MovieModel
+	Credits      CreditModel
+	Genres       GenreModel
+	Idempotency  IdempotencyModel
+	Movies       MovieModel
+	Outbox       OutboxModel
+	People       PersonModel
+	Permissions  PermissionModel
+	Reviews      ReviewModel
+	Tokens       TokenModel
+	Translations TranslationModel
+	Users        UserModel
+	Watchlist    WatchlistModel
+	Webhooks     WebhookModel
 }
 
-// Initializer for the MovieModel.
-func NewModels(db *sql.DB) Models {
+// Initializer for the models.
+// The movie cache is shared by every model whose changes affect a movie; pass nil to disable caching.
+func NewModels(db *sql.DB, movieCache *MovieCache) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
+		Credits:      CreditModel{DB: db},
+		Genres:       GenreModel{DB: db, movieCache: movieCache},
+		Idempotency:  IdempotencyModel{DB: db},
+		Movies:       MovieModel{DB: db, cache: movieCache},
+		Outbox:       OutboxModel{DB: db},
+		People:       PersonModel{DB: db},
+		Permissions:  PermissionModel{DB: db},
+		Reviews:      ReviewModel{DB: db, movieCache: movieCache},
+		Tokens:       TokenModel{DB: db},
+		Translations: TranslationModel{DB: db},
+		Users:        UserModel{DB: db},
+		Watchlist:    WatchlistModel{DB: db},
+		Webhooks:     WebhookModel{DB: db},
 	}
 }
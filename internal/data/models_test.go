@@ -0,0 +1,33 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestViolatesConstraint(t *testing.T) {
+	err := fmt.Errorf("inserting: %w", &pq.Error{Code: "23505", Constraint: "reviews_movie_user_key"})
+
+	tests := []struct {
+		name       string
+		err        error
+		code       pq.ErrorCode
+		constraint string
+		want       bool
+	}{
+		{"matching", err, uniqueViolation, "reviews_movie_user_key", true},
+		{"other constraint", err, uniqueViolation, "movie_credits_unique_key", false},
+		{"other code", err, foreignKeyViolation, "reviews_movie_user_key", false},
+		{"not a pq error", errors.New(`pq: duplicate key value violates unique constraint "reviews_movie_user_key"`), uniqueViolation, "reviews_movie_user_key", false},
+		{"nil", nil, uniqueViolation, "reviews_movie_user_key", false},
+	}
+
+	for _, tt := range tests {
+		if got := violatesConstraint(tt.err, tt.code, tt.constraint); got != tt.want {
+			t.Errorf("%s: got %t; want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package data
+
+import (
+	"container/list"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An in-process LRU cache of movies by id, sitting in front of MovieModel.Get.
+// Entries expire after the TTL, so changes made behind the API's back (e.g. by a migration)
+// show up eventually; changes made through the models invalidate the affected entries right away.
+//
+// A nil *MovieCache is valid & caches nothing, which is how caching is disabled.
+type MovieCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List // front = most recently used
+	entries map[int64]*list.Element
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+type movieCacheEntry struct {
+	movie   Movie
+	expires time.Time
+}
+
+// Counters exposed in the application metrics.
+type MovieCacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+	Size      int   `json:"size"`
+}
+
+func NewMovieCache(size int, ttl time.Duration) *MovieCache {
+	return &MovieCache{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[int64]*list.Element),
+	}
+}
+
+// Cached movies are copied in & out, so callers are free to modify what they get.
+func copyMovie(movie *Movie) *Movie {
+	c := *movie
+	c.Genres = slices.Clone(movie.Genres)
+	c.PosterThumbnailURLs = maps.Clone(movie.PosterThumbnailURLs)
+	return &c
+}
+
+func (c *MovieCache) get(id int64) (*Movie, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	entry := element.Value.(*movieCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(element)
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	c.hits.Add(1)
+
+	return copyMovie(&entry.movie), true
+}
+
+// Caches the movie, unless a newer version of it is cached already.
+// (A slow read racing with an update mustn't put the old version back.)
+func (c *MovieCache) set(movie *Movie) {
+	if c == nil || c.size < 1 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &movieCacheEntry{movie: *copyMovie(movie), expires: time.Now().Add(c.ttl)}
+
+	if element, ok := c.entries[movie.ID]; ok {
+		if element.Value.(*movieCacheEntry).movie.Version > movie.Version {
+			return
+		}
+
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[movie.ID] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *MovieCache) invalidate(id int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[id]; ok {
+		c.removeElement(element)
+	}
+}
+
+// Empties the cache; for changes affecting many movies at once (e.g. a genre merge).
+func (c *MovieCache) purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	clear(c.entries)
+}
+
+// N.B. the caller must hold the lock.
+func (c *MovieCache) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*movieCacheEntry).movie.ID)
+}
+
+func (c *MovieCache) Stats() MovieCacheStats {
+	if c == nil {
+		return MovieCacheStats{}
+	}
+
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return MovieCacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+		Size:      c.size,
+	}
+}
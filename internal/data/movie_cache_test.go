@@ -0,0 +1,118 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMovieCacheEviction(t *testing.T) {
+	c := NewMovieCache(2, time.Minute)
+
+	c.set(&Movie{ID: 1, Version: 1})
+	c.set(&Movie{ID: 2, Version: 1})
+
+	// Reading 1 makes 2 the least recently used, so it goes first.
+	if _, ok := c.get(1); !ok {
+		t.Fatal("movie 1 not cached")
+	}
+	c.set(&Movie{ID: 3, Version: 1})
+
+	for id, want := range map[int64]bool{1: true, 2: false, 3: true} {
+		if _, ok := c.get(id); ok != want {
+			t.Errorf("movie %d cached = %t; want %t", id, ok, want)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Evictions != 1 || stats.Entries != 2 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("got %+v; want 1 eviction, 2 entries, 3 hits & 1 miss", stats)
+	}
+}
+
+func TestMovieCacheExpiry(t *testing.T) {
+	// Entries expire as soon as they're written.
+	c := NewMovieCache(10, -time.Second)
+
+	c.set(&Movie{ID: 1, Version: 1})
+
+	if _, ok := c.get(1); ok {
+		t.Error("got an expired movie")
+	}
+
+	if entries := c.Stats().Entries; entries != 0 {
+		t.Errorf("got %d entries; want the expired one removed", entries)
+	}
+}
+
+func TestMovieCacheVersions(t *testing.T) {
+	c := NewMovieCache(10, time.Minute)
+
+	c.set(&Movie{ID: 1, Title: "v2", Version: 2})
+
+	// A stale read racing with the update mustn't put the old version back.
+	c.set(&Movie{ID: 1, Title: "v1", Version: 1})
+
+	movie, ok := c.get(1)
+	if !ok || movie.Title != "v2" {
+		t.Fatalf("got %+v; want version 2", movie)
+	}
+
+	c.set(&Movie{ID: 1, Title: "v3", Version: 3})
+
+	if movie, _ := c.get(1); movie.Title != "v3" {
+		t.Errorf("got %q; want the newer version", movie.Title)
+	}
+
+	// The cached copy isn't shared with the callers.
+	movie, _ = c.get(1)
+	movie.Title = "changed"
+	if movie, _ := c.get(1); movie.Title != "v3" {
+		t.Errorf("got %q; want the cached copy unchanged", movie.Title)
+	}
+}
+
+func TestMovieCacheInvalidate(t *testing.T) {
+	c := NewMovieCache(10, time.Minute)
+
+	c.set(&Movie{ID: 1, Version: 1})
+	c.set(&Movie{ID: 2, Version: 1})
+
+	c.invalidate(1)
+	c.invalidate(42) // not cached; nothing happens
+
+	if _, ok := c.get(1); ok {
+		t.Error("got an invalidated movie")
+	}
+	if _, ok := c.get(2); !ok {
+		t.Error("movie 2 was invalidated too")
+	}
+
+	// Invalidating allows an older version in again (e.g. after a revert).
+	c.invalidate(2)
+	c.set(&Movie{ID: 2, Version: 1})
+	if _, ok := c.get(2); !ok {
+		t.Error("movie 2 not cached again")
+	}
+
+	c.purge()
+	if entries := c.Stats().Entries; entries != 0 {
+		t.Errorf("got %d entries after purge; want 0", entries)
+	}
+}
+
+func TestMovieCacheNil(t *testing.T) {
+	// How caching is disabled (-cache-enabled=false): every call is a no-op.
+	var c *MovieCache
+
+	c.set(&Movie{ID: 1, Version: 1})
+	c.invalidate(1)
+	c.purge()
+
+	if _, ok := c.get(1); ok {
+		t.Error("a nil cache returned a movie")
+	}
+
+	if stats := c.Stats(); stats != (MovieCacheStats{}) {
+		t.Errorf("got %+v; want zero stats", stats)
+	}
+}
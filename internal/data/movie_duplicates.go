@@ -0,0 +1,202 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Movies are likely duplicates when they're from the same year & their titles are the same,
+// ignoring case, punctuation & spacing (see normalize_title() in the migrations); with a positive
+// threshold, also when their titles' trigram similarity (0-1) is at least that.
+func duplicateCondition(a, b string, threshold float64, thresholdParam string) string {
+	cond := `normalize_title(` + a + `) = normalize_title(` + b + `)`
+	if threshold > 0 {
+		cond = `(` + cond + ` OR similarity(` + a + `, ` + b + `) >= ` + thresholdParam + `)`
+	}
+
+	return cond
+}
+
+// The existing movies the given one would likely duplicate (at most 10), oldest first.
+func (m MovieModel) FindDuplicates(movie *Movie, threshold float64) ([]*Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return findDuplicates(ctx, m.DB, movie, threshold)
+}
+
+func findDuplicates(ctx context.Context, db interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}, movie *Movie, threshold float64) ([]*Movie, error) {
+	sel := movieColumnsFor(nil, "''")
+
+	q := `SELECT ` + sel.selectList() + `
+	FROM ` + sel.from() + `
+	WHERE year = $1 AND ` + duplicateCondition("title", "$2", threshold, "$3") + `
+	ORDER BY id
+	LIMIT 10`
+
+	args := []any{movie.Year, movie.Title}
+	if threshold > 0 {
+		args = append(args, threshold)
+	}
+
+	return queryMovies(ctx, db, sel, q, args...)
+}
+
+func queryMovies(ctx context.Context, db interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}, sel movieSelection, q string, args ...any) ([]*Movie, error) {
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(sel.dest(&movie)...)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// A group of movies which are likely duplicates of each other.
+type DuplicateCluster struct {
+	Movies []*Movie `json:"movies"`
+}
+
+// The clusters of likely duplicates among the existing movies, ordered by their oldest movie.
+// Duplication is transitive here: if A looks like B & B like C, all three form one cluster.
+//
+// N.B. with a positive threshold every pair of movies of the same year is compared, which gets
+// slow for very large catalogs.
+func (m MovieModel) GetDuplicateClusters(threshold float64, filters Filters) ([]*DuplicateCluster, Metadata, error) {
+	q := `SELECT a.id, b.id
+	FROM movies a
+	JOIN movies b ON b.year = a.year AND b.id > a.id
+	WHERE ` + duplicateCondition("a.title", "b.title", threshold, "$1")
+
+	var args []any
+	if threshold > 0 {
+		args = append(args, threshold)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	// Union-find over the pairs; every movie points (eventually) to the oldest one of its cluster.
+	parent := make(map[int64]int64)
+
+	var find func(id int64) int64
+	find = func(id int64) int64 {
+		p, ok := parent[id]
+		if !ok || p == id {
+			return id
+		}
+
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+
+	for rows.Next() {
+		var a, b int64
+
+		err := rows.Scan(&a, &b)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			parent[max(ra, rb)] = min(ra, rb)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	members := make(map[int64][]int64)
+	for id := range parent {
+		root := find(id)
+		members[root] = append(members[root], id)
+	}
+
+	roots := make([]int64, 0, len(members))
+	for root := range members {
+		roots = append(roots, root)
+	}
+	slices.Sort(roots)
+
+	metadata := calculateMetadata(len(roots), filters.Page, filters.PageSize)
+
+	start := min(filters.offset(), len(roots))
+	end := min(start+filters.limit(), len(roots))
+	roots = roots[start:end]
+
+	// The movies of the requested clusters.
+	var ids []int64
+	for _, root := range roots {
+		// The root itself only appears as a parent.
+		ids = append(ids, root)
+		ids = append(ids, members[root]...)
+	}
+
+	sel := movieColumnsFor(nil, "''")
+
+	movies, err := queryMovies(ctx, m.DB, sel, `SELECT `+sel.selectList()+`
+	FROM `+sel.from()+`
+	WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	byID := make(map[int64]*Movie, len(movies))
+	for _, movie := range movies {
+		byID[movie.ID] = movie
+	}
+
+	clusters := make([]*DuplicateCluster, 0, len(roots))
+
+	for _, root := range roots {
+		ids := append([]int64{root}, members[root]...)
+		slices.Sort(ids)
+		ids = slices.Compact(ids)
+
+		cluster := &DuplicateCluster{Movies: []*Movie{}}
+		for _, id := range ids {
+			// Deleted in the meantime.
+			if movie, ok := byID[id]; ok {
+				cluster.Movies = append(cluster.Movies, movie)
+			}
+		}
+
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters, metadata, nil
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateCondition(t *testing.T) {
+	tests := []struct {
+		threshold float64
+		want      string
+	}{
+		// Without a threshold only the normalized titles are compared...
+		{0, `normalize_title(a.title) = normalize_title(b.title)`},
+		// ... with one, similar titles are likely duplicates as well.
+		{0.8, `(normalize_title(a.title) = normalize_title(b.title) OR similarity(a.title, b.title) >= $1)`},
+	}
+
+	for _, tt := range tests {
+		if got := duplicateCondition("a.title", "b.title", tt.threshold, "$1"); got != tt.want {
+			t.Errorf("duplicateCondition(%v) = %s; want %s", tt.threshold, got, tt.want)
+		}
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	tests := []struct {
+		threshold  float64
+		similarity bool
+		args       []any
+	}{
+		{threshold: 0, similarity: false, args: []any{int64(1995), "Heat"}},
+		{threshold: 0.8, similarity: true, args: []any{int64(1995), "Heat", 0.8}},
+	}
+
+	for _, tt := range tests {
+		db := &stubDB{}
+
+		movies := MovieModel{DB: sql.OpenDB(db)}
+		defer movies.DB.Close()
+
+		_, err := movies.FindDuplicates(&Movie{Title: "Heat", Year: 1995}, tt.threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(db.queries) != 1 {
+			t.Fatalf("threshold %v: %d queries; want 1", tt.threshold, len(db.queries))
+		}
+		query := db.queries[0]
+
+		if !strings.Contains(query.query, "WHERE year = $1 AND") {
+			t.Errorf("threshold %v: the query doesn't restrict the year: %s", tt.threshold, query.query)
+		}
+
+		if got := strings.Contains(query.query, "similarity(title, $2) >= $3"); got != tt.similarity {
+			t.Errorf("threshold %v: the query compares the similarity: %t; want %t", tt.threshold, got, tt.similarity)
+		}
+
+		if !slices.Equal(query.args, tt.args) {
+			t.Errorf("threshold %v: args = %v; want %v", tt.threshold, query.args, tt.args)
+		}
+	}
+}
+
+func TestGetDuplicateClusters(t *testing.T) {
+	// 1 ~ 4 ~ 7 (so 1 & 7 are in one cluster, though they don't look alike), 2 ~ 9 & 3 ~ 5.
+	pairs := [][2]int64{{4, 7}, {2, 9}, {1, 4}, {3, 5}}
+
+	tests := []struct {
+		name      string
+		page      int
+		threshold float64
+		deleted   map[int64]bool
+		want      [][]int64
+	}{
+		{name: "first page", page: 1, want: [][]int64{{1, 4, 7}, {2, 9}}},
+		{name: "second page", page: 2, want: [][]int64{{3, 5}}},
+		{name: "beyond the last page", page: 3, want: [][]int64{}},
+		{name: "deleted meanwhile", page: 1, deleted: map[int64]bool{4: true}, want: [][]int64{{1, 7}, {2, 9}}},
+		{name: "threshold", page: 1, threshold: 0.8, want: [][]int64{{1, 4, 7}, {2, 9}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &stubDB{pairs: pairs, deleted: tt.deleted}
+
+			movies := MovieModel{DB: sql.OpenDB(db)}
+			defer movies.DB.Close()
+
+			clusters, metadata, err := movies.GetDuplicateClusters(tt.threshold, Filters{Page: tt.page, PageSize: 2, Sort: "id", SortSafelist: []string{"id"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([][]int64, len(clusters))
+			for i, cluster := range clusters {
+				got[i] = []int64{}
+				for _, movie := range cluster.Movies {
+					got[i] = append(got[i], movie.ID)
+				}
+			}
+
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("clusters = %v; want %v", got, tt.want)
+			}
+
+			// The clusters are paginated, not the movies.
+			if metadata.TotalRecords != 3 || metadata.LastPage != 2 {
+				t.Errorf("metadata = %+v; want 3 clusters on 2 pages", metadata)
+			}
+
+			// The threshold only comes into play when it's positive.
+			pairQuery := db.queries[0]
+
+			if got := strings.Contains(pairQuery.query, "similarity(a.title, b.title) >= $1"); got != (tt.threshold > 0) {
+				t.Errorf("the query compares the similarity: %t; want %t", got, tt.threshold > 0)
+			}
+
+			if tt.threshold > 0 && !slices.Equal(pairQuery.args, []any{tt.threshold}) {
+				t.Errorf("args = %v; want [%v]", pairQuery.args, tt.threshold)
+			}
+		})
+	}
+}
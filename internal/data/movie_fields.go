@@ -0,0 +1,160 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+	"github.com/lib/pq"
+)
+
+// The fields a sparse fieldset may contain: the JSON field names of a Movie (from its struct tags),
+// in struct order. Most are read from a column (see movieColumns), except for:
+//   - highlight, which is computed by title searches (& empty otherwise);
+//   - title_locale & original_title, which are left out: they're set when the title is translated,
+//     so they only ever come along with the title (see TranslationModel.Localize).
+var movieFieldNames = slices.DeleteFunc(jsonFieldNames(reflect.TypeOf(Movie{})), func(name string) bool {
+	return name == "title_locale" || name == "original_title"
+})
+
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Checks a sparse fieldset, e.g. ["id", "title", "year"], against the Movie JSON fields.
+func ValidateMovieFields(v *validator.Validator, fields []string) {
+	for _, field := range fields {
+		if !slices.Contains(movieFieldNames, field) {
+			v.AddError("fields", fmt.Sprintf("unknown field %q (must be one of %s)", field, strings.Join(movieFieldNames, ", ")))
+			return
+		}
+	}
+}
+
+// How a movie field is read from the db: the SELECT expression & where to scan it to.
+type movieColumn struct {
+	expr string
+	dest func(movie *Movie) any
+}
+
+// N.B. "highlight" is missing on purpose; it depends on the search (see movieColumnsFor).
+var movieColumns = map[string]movieColumn{
+	"id":             {"id", func(m *Movie) any { return &m.ID }},
+	"created_at":     {"created_at", func(m *Movie) any { return &m.CreatedAt }},
+	"title":          {"title", func(m *Movie) any { return &m.Title }},
+	"year":           {"year", func(m *Movie) any { return &m.Year }},
+	"runtime":        {"runtime", func(m *Movie) any { return &m.Runtime }},
+	"genres":         {"genres", func(m *Movie) any { return pq.Array(&m.Genres) }},
+	"version":        {"version", func(m *Movie) any { return &m.Version }},
+	"average_rating": {"r.average_rating", func(m *Movie) any { return &m.AverageRating }},
+	"rating_count":   {"r.rating_count", func(m *Movie) any { return &m.RatingCount }},
+	// Both are derived from the poster column.
+	"poster_url":            {"poster", func(m *Movie) any { return posterScanner{m} }},
+	"poster_thumbnail_urls": {"poster", func(m *Movie) any { return posterScanner{m} }},
+}
+
+// A SELECT list for a subset of the movie fields.
+type movieSelection struct {
+	columns []movieColumn
+	ratings bool // whether the ratingsJoin is needed
+}
+
+// Works out the columns to read for the given JSON fields; nil means every field.
+// The id (& the extra internal fields) are always read, as sorting & paging rely on them.
+func movieColumnsFor(fields []string, highlightExpr string, extra ...string) movieSelection {
+	if fields == nil {
+		fields = append([]string{"created_at"}, movieFieldNames...)
+	}
+
+	var sel movieSelection
+	seen := make(map[string]bool)
+	// Several fields may share a column.
+	selected := make(map[string]bool)
+
+	for _, field := range append(append([]string{"id"}, extra...), fields...) {
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+
+		if field == "highlight" {
+			sel.columns = append(sel.columns, movieColumn{highlightExpr, func(m *Movie) any { return &m.Highlight }})
+			continue
+		}
+
+		column, ok := movieColumns[field]
+		if !ok || selected[column.expr] {
+			continue
+		}
+		selected[column.expr] = true
+
+		if strings.HasPrefix(column.expr, "r.") {
+			sel.ratings = true
+		}
+
+		sel.columns = append(sel.columns, column)
+	}
+
+	return sel
+}
+
+func (sel movieSelection) selectList() string {
+	exprs := make([]string, len(sel.columns))
+	for i, column := range sel.columns {
+		exprs[i] = column.expr
+	}
+
+	return strings.Join(exprs, ", ")
+}
+
+func (sel movieSelection) from() string {
+	if sel.ratings {
+		return "movies\n\t" + ratingsJoin
+	}
+
+	return "movies"
+}
+
+func (sel movieSelection) dest(movie *Movie) []any {
+	dest := make([]any, len(sel.columns))
+	for i, column := range sel.columns {
+		dest[i] = column.dest(movie)
+	}
+
+	return dest
+}
+
+// Returns only the requested fields of the movie, keyed by their JSON names.
+// Values keep their Go types, so e.g. the runtime is still encoded as "102 mins".
+func (m *Movie) Project(fields []string) map[string]any {
+	projected := make(map[string]any, len(fields))
+
+	v := reflect.ValueOf(m).Elem()
+	t := v.Type()
+
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if slices.Contains(fields, name) {
+			projected[name] = v.Field(i).Interface()
+		}
+	}
+
+	// A translated title comes with its locale & the original (see movieFieldNames).
+	if slices.Contains(fields, "title") && m.TitleLocale != "" {
+		projected["title_locale"] = m.TitleLocale
+		projected["original_title"] = m.OriginalTitle
+	}
+
+	return projected
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+)
+
+func TestValidateMovieFields(t *testing.T) {
+	tests := []struct {
+		fields []string
+		valid  bool
+	}{
+		{[]string{"id", "title", "year"}, true},
+		{[]string{"highlight"}, true},
+		{[]string{"poster_url", "poster_thumbnail_urls"}, true},
+		{[]string{"title_locale"}, false},
+		{[]string{"title", "original_title"}, false},
+		{[]string{"poster"}, false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateMovieFields(v, tt.fields)
+
+		if v.Valid() != tt.valid {
+			t.Errorf("%v: valid = %t; want %t (%v)", tt.fields, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
+
+func TestMovieColumnsFor(t *testing.T) {
+	// Every field: the derived ones aren't read, & the poster column is read once.
+	sel := movieColumnsFor(nil, "''")
+	want := "id, created_at, title, year, runtime, genres, version, r.average_rating, r.rating_count, '', poster"
+	if got := sel.selectList(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	sel = movieColumnsFor([]string{"poster_thumbnail_urls", "poster_url"}, "''")
+	if got := sel.selectList(); got != "id, poster" {
+		t.Errorf("got %q; want %q", got, "id, poster")
+	}
+}
+
+func TestMovieProject(t *testing.T) {
+	movie := &Movie{ID: 1, Title: "Das Boot", Year: 1981}
+
+	if got := movie.Project([]string{"title"}); !reflect.DeepEqual(got, map[string]any{"title": "Das Boot"}) {
+		t.Errorf("got %v; want the title only", got)
+	}
+
+	// Translated: the locale & the original come along with the title.
+	movie.Title, movie.TitleLocale, movie.OriginalTitle = "The Boat", "en", "Das Boot"
+
+	got := movie.Project([]string{"id", "title"})
+	want := map[string]any{"id": int64(1), "title": "The Boat", "title_locale": "en", "original_title": "Das Boot"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	if got := movie.Project([]string{"year"}); !reflect.DeepEqual(got, map[string]any{"year": int32(1981)}) {
+		t.Errorf("got %v; want the year only", got)
+	}
+}
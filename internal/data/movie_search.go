@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+	"github.com/lib/pq"
+)
+
+// The search criteria of the movie list; zero values disable the corresponding filter.
+type MovieSearch struct {
+	Title  string
+	Genres []string
+	// By default a movie must have ALL the genres; AnyGenre matches movies having at least one.
+	AnyGenre bool
+	PersonID int64 // only movies crediting this person
+	// Inclusive ranges; 0 means unbounded.
+	YearMin    int
+	YearMax    int
+	RuntimeMin Runtime
+	RuntimeMax Runtime
+	// Fuzzy switches the title search from full-text to trigram similarity (typo tolerant).
+	// Titles with a similarity below FuzzyThreshold (0-1) are left out.
+	Fuzzy          bool
+	FuzzyThreshold float64
+	// The JSON fields to read (a sparse fieldset); nil means all of them.
+	Fields []string
+}
+
+// The query arguments referenced by whereClause() as $1, $2 ...
+func (s MovieSearch) args() []any {
+	return []any{s.Title, pq.Array(s.Genres), s.PersonID, s.YearMin, s.YearMax, s.RuntimeMin, s.RuntimeMax}
+}
+
+func ValidateMovieSearch(v *validator.Validator, s MovieSearch) {
+	maxYear := time.Now().Year()
+
+	// Same bounds as the movies_year_check constraint (migration 000002).
+	if s.YearMin != 0 {
+		v.Check(s.YearMin >= 1888 && s.YearMin <= maxYear, "year_min", fmt.Sprintf("must be between 1888 and %d", maxYear))
+	}
+	if s.YearMax != 0 {
+		v.Check(s.YearMax >= 1888 && s.YearMax <= maxYear, "year_max", fmt.Sprintf("must be between 1888 and %d", maxYear))
+	}
+	if s.YearMin != 0 && s.YearMax != 0 {
+		v.Check(s.YearMin <= s.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	v.Check(s.RuntimeMin >= 0, "runtime_min", "must be a positive integer")
+	v.Check(s.RuntimeMax >= 0, "runtime_max", "must be a positive integer")
+	if s.RuntimeMin != 0 && s.RuntimeMax != 0 {
+		v.Check(s.RuntimeMin <= s.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+}
+
+// The WHERE clause selecting the matching rows of the movies table.
+func (s MovieSearch) whereClause() string {
+	// The title matches when the original or any of its translations does.
+	matches := func(title string) string {
+		return `to_tsvector('simple', ` + title + `) @@ plainto_tsquery('simple', $1)`
+	}
+
+	// The % operator (unlike comparing similarity() to a value) can use the trigram index.
+	// Its threshold is a setting though; see beginSearch().
+	if s.Fuzzy {
+		matches = func(title string) string {
+			return title + ` % $1`
+		}
+	}
+
+	titleMatch := matches("title") + ` OR EXISTS (
+		SELECT 1 FROM movie_translations t WHERE t.movie_id = movies.id AND ` + matches("t.title") + `
+	)`
+	if s.Fuzzy {
+		titleMatch = `(` + titleMatch + `)`
+	} else {
+		titleMatch = `($1 = '' OR ` + titleMatch + `)`
+	}
+
+	// && is array overlap, @> is containment.
+	genreMatch := `(genres @> $2 OR $2 = '{}')`
+	if s.AnyGenre {
+		genreMatch = `(genres && $2 OR $2 = '{}')`
+	}
+
+	conditions := []string{
+		titleMatch,
+		genreMatch,
+		`($3 = 0 OR EXISTS (
+		SELECT 1 FROM movie_credits WHERE movie_credits.movie_id = movies.id AND movie_credits.person_id = $3
+	))`,
+		`($4 = 0 OR year >= $4)`,
+		`($5 = 0 OR year <= $5)`,
+		`($6 = 0 OR runtime >= $6)`,
+		`($7 = 0 OR runtime <= $7)`,
+	}
+
+	return "WHERE " + strings.Join(conditions, "\n\tAND ")
+}
+
+// How well a title matches the search; used for sort=relevance.
+// A movie ranks by the best matching of its original & translated titles.
+func (s MovieSearch) relevanceExpr() string {
+	score := func(title string) string {
+		return `ts_rank(to_tsvector('simple', ` + title + `), plainto_tsquery('simple', $1))`
+	}
+
+	if s.Fuzzy {
+		score = func(title string) string {
+			return `similarity(` + title + `, $1)`
+		}
+	}
+
+	// The translations are only scored when there's a title search (GREATEST ignores the NULL).
+	return `GREATEST(` + score("title") + `, CASE WHEN $1 = '' THEN NULL ELSE (
+		SELECT max(` + score("t.title") + `) FROM movie_translations t WHERE t.movie_id = movies.id
+	) END)`
+}
+
+// The title with the search terms marked up; fuzzy matches are not highlighted.
+// N.B. only the original title is highlighted, even when a translation matched.
+//
+// The title is HTML-escaped first: clients render the <mark> tags, so nothing else in the
+// (user-supplied) title may be markup. The text search parser leaves the entities alone.
+func (s MovieSearch) highlightExpr() string {
+	if s.Fuzzy {
+		return `''`
+	}
+
+	escaped := `replace(replace(replace(replace(replace(title, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;')`
+
+	return `CASE WHEN $1 = '' THEN ''
+		ELSE ts_headline('simple', ` + escaped + `, plainto_tsquery('simple', $1), 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')
+		END`
+}
+
+// Starts a read-only transaction for running search queries.
+// For fuzzy searches, the similarity threshold is set for the duration of the transaction only.
+func (m MovieModel) beginSearch(ctx context.Context, s MovieSearch) (*sql.Tx, error) {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Fuzzy {
+		threshold := strconv.FormatFloat(s.FuzzyThreshold, 'f', -1, 64)
+
+		_, err = tx.ExecContext(ctx, "SELECT set_config('pg_trgm.similarity_threshold', $1, true)", threshold)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// The facets which can be counted over a movie search.
+var FacetSafelist = []string{"genres", "decade", "runtime_bucket"}
+
+// The number of matching movies sharing a value, e.g. {"value": "drama", "count": 120}.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Each facet query yields (value, count) rows; the value expressions don't depend on user input.
+var facetQueries = map[string]string{
+	// Most common genres first.
+	"genres": `SELECT g.genre, count(*)
+	FROM movies CROSS JOIN LATERAL unnest(movies.genres) AS g(genre)
+	%s
+	GROUP BY g.genre
+	ORDER BY count(*) DESC, g.genre`,
+
+	// e.g. "1990s"
+	"decade": `SELECT ((year / 10) * 10)::text || 's', count(*)
+	FROM movies
+	%s
+	GROUP BY year / 10
+	ORDER BY year / 10`,
+
+	"runtime_bucket": `SELECT CASE
+		WHEN runtime < 90 THEN '< 90 mins'
+		WHEN runtime < 120 THEN '90-119 mins'
+		WHEN runtime < 150 THEN '120-149 mins'
+		ELSE '150+ mins'
+	END, count(*)
+	FROM movies
+	%s
+	GROUP BY 1
+	ORDER BY min(runtime)`,
+}
+
+// Counts the movies matching the search (ignoring pagination) per value of each requested facet.
+func (m MovieModel) GetFacets(search MovieSearch, facets []string) (map[string][]FacetCount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.beginSearch(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := make(map[string][]FacetCount, len(facets))
+
+	for _, facet := range facets {
+		tmpl, ok := facetQueries[facet]
+		if !ok {
+			return nil, fmt.Errorf("unknown facet: %s", facet)
+		}
+
+		counts, err := queryFacet(ctx, tx, fmt.Sprintf(tmpl, search.whereClause()), search.args())
+		if err != nil {
+			return nil, err
+		}
+
+		result[facet] = counts
+	}
+
+	return result, nil
+}
+
+func queryFacet(ctx context.Context, tx *sql.Tx, q string, args []any) ([]FacetCount, error) {
+	rows, err := tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+
+	for rows.Next() {
+		var fc FacetCount
+
+		err := rows.Scan(&fc.Value, &fc.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, fc)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
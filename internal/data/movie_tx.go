@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Movie changes made together in a single transaction, e.g. an atomic batch.
+// It offers the same reads & writes as MovieModel; none of the changes are visible to others
+// (nor cached) before Commit.
+type MovieTx struct {
+	tx    *sql.Tx
+	cache *MovieCache
+
+	// The change events to write as it commits (see commitOutbox).
+	events []outboxEntry
+
+	// The cache updates to make once committed.
+	updated []*Movie
+	deleted []int64
+}
+
+func (m MovieModel) Begin() (*MovieTx, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MovieTx{tx: tx, cache: m.cache}, nil
+}
+
+// Reads the movie as the transaction sees it; the cache is bypassed.
+func (t *MovieTx) Get(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return getMovie(t.tx, id, nil)
+}
+
+// Sees the movies created earlier in the transaction too.
+func (t *MovieTx) FindDuplicates(movie *Movie, threshold float64) ([]*Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return findDuplicates(ctx, t.tx, movie, threshold)
+}
+
+func (t *MovieTx) Insert(movie *Movie) error {
+	entry, err := insertMovie(t.tx, movie)
+	if err != nil {
+		return err
+	}
+
+	t.events = append(t.events, entry)
+
+	return nil
+}
+
+func (t *MovieTx) Update(movie *Movie) error {
+	entry, err := updateMovie(t.tx, movie)
+	if err != nil {
+		if errors.Is(err, ErrEditConflict) {
+			t.cache.invalidate(movie.ID)
+		}
+		return err
+	}
+
+	t.events = append(t.events, entry)
+
+	c := *movie
+	t.updated = append(t.updated, &c)
+
+	return nil
+}
+
+func (t *MovieTx) Delete(id int64) error {
+	return t.DeleteVersion(id, 0)
+}
+
+func (t *MovieTx) DeleteVersion(id int64, version int32) error {
+	entry, err := deleteMovie(t.tx, id, version)
+	if err != nil {
+		return err
+	}
+
+	t.events = append(t.events, entry)
+	t.deleted = append(t.deleted, id)
+
+	return nil
+}
+
+func (t *MovieTx) Commit() error {
+	err := commitOutbox(t.tx, t.events...)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range t.updated {
+		t.cache.set(movie)
+	}
+
+	// A movie updated & then deleted in the same transaction ends up deleted.
+	for _, id := range t.deleted {
+		t.cache.invalidate(id)
+	}
+
+	return nil
+}
+
+// Safe to call after Commit (it does nothing then), so it can be deferred.
+func (t *MovieTx) Rollback() error {
+	err := t.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FieldChange describes how a single movie field changed between two versions.
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Records the current state of the movie as a row in its history.
+// Must be called inside the same transaction that wrote the movie itself.
+func insertMovieVersion(tx *sql.Tx, movie *Movie) error {
+	q := `INSERT INTO movie_versions (movie_id, version, title, year, runtime, genres)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+
+	args := []any{movie.ID, movie.Version, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+	_, err := tx.Exec(q, args...)
+	return err
+}
+
+// Fetches the state a movie had at the given version.
+// The returned movie carries the historical version number, not the current one.
+func (m MovieModel) GetVersion(id int64, version int32) (*Movie, error) {
+	if id < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	q := `SELECT movie_id, created_at, title, year, runtime, genres, version
+	FROM movie_versions
+	WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var movie Movie
+
+	err := m.DB.QueryRowContext(ctx, q, id, version).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// Compares the editable fields of two movies; keyed by their JSON names.
+// Fields which are identical are left out, so an empty map means "no changes".
+func DiffMovies(before, after *Movie) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if before.Title != after.Title {
+		changes["title"] = FieldChange{From: before.Title, To: after.Title}
+	}
+
+	if before.Year != after.Year {
+		changes["year"] = FieldChange{From: before.Year, To: after.Year}
+	}
+
+	if before.Runtime != after.Runtime {
+		changes["runtime"] = FieldChange{From: before.Runtime, To: after.Runtime}
+	}
+
+	if !slices.Equal(before.Genres, after.Genres) {
+		changes["genres"] = FieldChange{From: before.Genres, To: after.Genres}
+	}
+
+	return changes
+}
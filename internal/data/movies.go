@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/heschmat/go_movies_api_rest/internal/validator"
@@ -20,13 +23,28 @@ type Movie struct {
 	Runtime   Runtime     `json:"runtime"` // Movie runtime (in minutes)
 	Genres    []string  `json:"genres"`  // Slice of genres for the movie (drama, comedy, romance ...)
 	Version   int32     `json:"version"` // starts at 1; will be incremented each time movie info is updated
+	// Aggregates of the user reviews; read-only & computed when the movie is fetched.
+	AverageRating float64 `json:"average_rating"`
+	RatingCount   int64   `json:"rating_count"`
+	// The HTML-escaped title with the search terms wrapped in <mark> tags; only set for title searches.
+	Highlight string `json:"highlight,omitempty"`
+	// Set when the title is a translation (see TranslationModel.Localize): its locale & the original title.
+	TitleLocale   string `json:"title_locale,omitempty"`
+	OriginalTitle string `json:"original_title,omitempty"`
+	// The storage key of the poster image; "" without a poster (see SetPoster).
+	Poster string `json:"-"`
+	// Where the poster & its thumbnails (by width, e.g. "w185") are served; only set with a poster.
+	PosterURL           string            `json:"poster_url,omitempty"`
+	PosterThumbnailURLs map[string]string `json:"poster_thumbnail_urls,omitempty"`
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	cache *MovieCache // nil when caching is disabled
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// The genres are checked against the taxonomy; normalize them with taxonomy.Normalize() first.
+func ValidateMovie(v *validator.Validator, movie *Movie, taxonomy *GenreTaxonomy) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
@@ -40,39 +58,97 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	validateGenres(v, movie.Genres, taxonomy)
 }
 
+// Computes the review aggregates for every selected movie row.
+// The average is rounded to 1 decimal place & is 0 for movies without any reviews.
+const ratingsJoin = `LEFT JOIN LATERAL (
+		SELECT COALESCE(ROUND(AVG(rating), 1), 0)::float8 AS average_rating, COUNT(*) AS rating_count
+		FROM reviews
+		WHERE reviews.movie_id = movies.id
+	) r ON true`
+
 // CRUD operations ========================================================== #
 func (m MovieModel) Insert(movie *Movie) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	entry, err := insertMovie(tx, movie)
+	if err != nil {
+		return err
+	}
+
+	return commitOutbox(tx, entry)
+}
+
+// The movie row & its first history entry are written in the given transaction;
+// the change event is returned, to be written as it commits.
+func insertMovie(tx *sql.Tx, movie *Movie) (outboxEntry, error) {
 	q := `INSERT INTO movies (title, year, runtime, genres)
 	VALUES ($1, $2, $3, $4)
 	RETURNING id, created_at, version`
 
 	queryArgs := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	return m.DB.QueryRow(q, queryArgs...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := tx.QueryRow(q, queryArgs...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return outboxEntry{}, err
+	}
+
+	err = insertMovieVersion(tx, movie)
+	if err != nil {
+		return outboxEntry{}, err
+	}
+
+	return newOutboxEntry(EventMovieCreated, map[string]any{"movie": movie})
 }
 
+// Served from the movie cache when possible.
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.GetFields(id, nil)
+}
+
+// Like Get, but only reads the columns needed for the given JSON fields (nil means all).
+// A cached movie has every field, so it's returned whatever the fields; only full reads are cached.
+func (m MovieModel) GetFields(id int64, fields []string) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	q := `SELECT id, created_at, title, year, runtime, genres, version
-	FROM movies
+	if movie, ok := m.cache.get(id); ok {
+		return movie, nil
+	}
+
+	movie, err := getMovie(m.DB, id, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if fields == nil {
+		m.cache.set(movie)
+	}
+
+	// Return a pointer to the *Movie* struct.
+	return movie, nil
+}
+
+// Reads a movie through the connection pool or a transaction.
+func getMovie(db interface {
+	QueryRow(string, ...any) *sql.Row
+}, id int64, fields []string) (*Movie, error) {
+	sel := movieColumnsFor(fields, "''")
+
+	q := `SELECT ` + sel.selectList() + `
+	FROM ` + sel.from() + `
 	WHERE id = $1`
 
 	var movie Movie
 
-	err := m.DB.QueryRow(q, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := db.QueryRow(q, id).Scan(sel.dest(&movie)...)
 
 	// Handle the errors.
 	// If no matching movie found, .Scan() returns a *sql.ErrNoRows* error.
@@ -86,38 +162,119 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
-	// Return a pointer to the *Movie* struct.
 	return &movie, nil
 }
 
+// The movie's history, reviews, credits & watchlist items are removed by ON DELETE CASCADE.
 func (m MovieModel) Delete(id int64) error {
-	if id < 1 {
-		return ErrRecordNotFound
+	return m.delete(id, 0)
+}
+
+// Like Delete, but only deletes the movie while it's still at the given version;
+// otherwise ErrEditConflict is returned.
+func (m MovieModel) DeleteVersion(id int64, version int32) error {
+	return m.delete(id, version)
+}
+
+func (m MovieModel) delete(id int64, version int32) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	q := "DELETE FROM movies WHERE id = $1"
-	result, err := m.DB.Exec(q, id)
+	entry, err := deleteMovie(tx, id, version)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	err = commitOutbox(tx, entry)
 	if err != nil {
 		return err
 	}
 
+	m.cache.invalidate(id)
+
+	return nil
+}
+
+// The deletion is written in the given transaction; the change event is returned, to be written
+// as it commits.
+// A version of 0 deletes the movie whatever its version.
+func deleteMovie(tx *sql.Tx, id int64, version int32) (outboxEntry, error) {
+	if id < 1 {
+		return outboxEntry{}, ErrRecordNotFound
+	}
+
+	q := "DELETE FROM movies WHERE id = $1 AND ($2 = 0 OR version = $2)"
+
+	result, err := tx.Exec(q, id, version)
+	if err != nil {
+		return outboxEntry{}, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return outboxEntry{}, err
+	}
+
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		if version == 0 {
+			return outboxEntry{}, ErrRecordNotFound
+		}
+
+		// Tell a missing movie from one which moved on to another version.
+		var exists bool
+		err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM movies WHERE id = $1)", id).Scan(&exists)
+		if err != nil {
+			return outboxEntry{}, err
+		}
+
+		if exists {
+			return outboxEntry{}, ErrEditConflict
+		}
+		return outboxEntry{}, ErrRecordNotFound
 	}
 
-	return nil
+	return newOutboxEntry(EventMovieDeleted, map[string]any{"movie": map[string]any{"id": id}})
 }
 
+// Optimistic locking: the update only applies if the movie is still at movie.Version,
+// i.e. nobody changed it since it was read; otherwise ErrEditConflict is returned.
+// The updated movie replaces any cached (older) version.
 func (m MovieModel) Update (movie *Movie) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	entry, err := updateMovie(tx, movie)
+	if err != nil {
+		if errors.Is(err, ErrEditConflict) {
+			// Whatever copy is cached is out of date too.
+			m.cache.invalidate(movie.ID)
+		}
+		return err
+	}
+
+	err = commitOutbox(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	m.cache.set(movie)
+
+	return nil
+}
+
+// Every new version is also recorded in the movie's history, in the given transaction;
+// the change event is returned, to be written as it commits.
+func updateMovie(tx *sql.Tx, movie *Movie) (outboxEntry, error) {
 	q := `UPDATE movies
 	SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-	WHERE id = $5
-	RETURNING version`
+	WHERE id = $5 AND version = $6
+	RETURNING version, poster`
 
 	args := []any{
 		movie.Title,
@@ -125,57 +282,204 @@ func (m MovieModel) Update (movie *Movie) error {
 		movie.Runtime,
 		pq.Array(movie.Genres),
 		movie.ID,
+		movie.Version,
 	}
 
-	return m.DB.QueryRow(q, args...).Scan(&movie.Version)
+	// The poster is read back too, as it may have changed since the movie was read.
+	err := tx.QueryRow(q, args...).Scan(&movie.Version, posterScanner{movie})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return outboxEntry{}, ErrEditConflict
+		default:
+			return outboxEntry{}, err
+		}
+	}
+
+	err = insertMovieVersion(tx, movie)
+	if err != nil {
+		return outboxEntry{}, err
+	}
+
+	return newOutboxEntry(EventMovieUpdated, map[string]any{"movie": movie})
 }
 
-func (m MovieModel) GetMovies(title string, genres []string) ([]*Movie, error) {
-	q := `SELECT id, created_at, title, year, runtime, genres, version
-	FROM movies
-	--WHERE (LOWER(title) = LOWER($1) OR $1 = '')
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}')
-	ORDER BY id`
+// A personID of 0 disables the filter; otherwise only movies crediting that person are returned.
+// Sorting by "relevance" (only meaningful with a title search) ranks the best matches first;
+// i.e. by ts_rank for full-text searches & by similarity for fuzzy ones.
+//
+// When filters carry a cursor, keyset pagination is used instead of LIMIT/OFFSET:
+// only the rows after (or before) the cursor position are read, which stays fast on deep pages.
+func (m MovieModel) GetMovies(search MovieSearch, filters Filters) ([]*Movie, Metadata, error) {
+	sortExpr, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+	if sortExpr == "relevance" {
+		sortExpr, desc = search.relevanceExpr(), true
+	}
+
+	args := search.args()
+	where := search.whereClause()
+	keyset := filters.cursor != nil
+	backward := keyset && filters.cursor.Backward
+
+	// count(*) OVER() gives us the total number of matching records (before LIMIT/OFFSET) on every row.
+	// It's skipped for keyset pagination, where it would mean scanning every matching row anyway.
+	total := "count(*) OVER()"
+	limit, offset := filters.limit(), filters.offset()
+
+	if keyset {
+		key, _ := filters.cursor.keyArg()
+		args = append(args, key, filters.cursor.ID)
+		k, id := len(args)-1, len(args)
+
+		// The rows after the cursor in sort order (or before it, when paging backwards).
+		// The id breaks ties, so no row is skipped or repeated.
+		cmp, idCmp := ">", ">"
+		if desc != backward {
+			cmp = "<"
+		}
+		if backward {
+			idCmp = "<"
+		}
+
+		where += fmt.Sprintf("\n\tAND (%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id %[4]s $%[5]d))", sortExpr, cmp, k, idCmp, id)
+
+		total = "0"
+		// Read one extra row to find out whether there's yet another page.
+		limit, offset = filters.limit()+1, 0
+	}
+
+	direction, idDirection := "ASC", "ASC"
+	if desc != backward {
+		direction = "DESC"
+	}
+	if backward {
+		idDirection = "DESC"
+	}
+
+	args = append(args, limit, offset)
+
+	// Only the requested fields are read, plus whatever the sorting needs.
+	sel := movieColumnsFor(search.Fields, search.highlightExpr(), filters.sortColumn())
+
+	// The id is used as secondary sort key, so the order is stable across pages.
+	q := fmt.Sprintf(`SELECT %s, %s,
+		%s AS relevance
+	FROM %s
+	%s
+	ORDER BY %s %s, id %s
+	LIMIT $%d OFFSET $%d`,
+		total, sel.selectList(), search.relevanceExpr(), sel.from(), where,
+		sortExpr, direction, idDirection, len(args)-1, len(args))
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
 	defer cancel()
 
+	tx, err := m.beginSearch(ctx, search)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer tx.Rollback()
+
 	// Execute the query.
-	rows, err := m.DB.QueryContext(ctx, q, title, pq.Array(genres))
+	rows, err := tx.QueryContext(ctx, q, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	// Initialize an empty slice to hold the fetched record(s).
 	movies := []*Movie{}
+	// The relevance of each movie; needed to build cursors for sort=relevance.
+	relevances := []float64{}
 
 	for rows.Next() {
 		var movie Movie
+		var relevance float64
 
-		err := rows.Scan(
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		dest := append([]any{&totalRecords}, sel.dest(&movie)...)
+		dest = append(dest, &relevance)
+
+		err := rows.Scan(dest...)
 
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		movies = append(movies, &movie)
+		relevances = append(relevances, relevance)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
+	}
+
+	if !keyset {
+		metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+		hasNext := filters.offset()+len(movies) < totalRecords
+		hasPrev := filters.Page > 1 && len(movies) > 0
+		filters.setCursors(&metadata, movies, relevances, hasNext, hasPrev)
+
+		// If everything went ok, return the movies slice & the pagination metadata.
+		return movies, metadata, nil
+	}
+
+	// Drop the extra row; it only told us there's more to come in the direction we're reading.
+	more := len(movies) > filters.limit()
+	if more {
+		movies, relevances = movies[:filters.limit()], relevances[:filters.limit()]
 	}
 
-	// If everything went ok, return the movies slice.
-	return movies, nil
+	// Backward pages were read in reverse order.
+	if backward {
+		slices.Reverse(movies)
+		slices.Reverse(relevances)
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+
+	// The cursor row itself lies beyond the page in the opposite direction.
+	hasNext, hasPrev := more, true
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+	filters.setCursors(&metadata, movies, relevances, hasNext, hasPrev)
+
+	return movies, metadata, nil
+}
+
+// Fills in the next/prev cursors for a page of movies (if the filters can sign cursors).
+func (f Filters) setCursors(metadata *Metadata, movies []*Movie, relevances []float64, hasNext, hasPrev bool) {
+	if f.CursorKey == nil || len(movies) == 0 {
+		return
+	}
+
+	at := func(i int, backward bool) string {
+		c := cursor{Sort: f.Sort, ID: movies[i].ID, Backward: backward}
+
+		switch f.sortColumn() {
+		case "title":
+			c.Key = movies[i].Title
+		case "year":
+			c.Key = strconv.FormatInt(int64(movies[i].Year), 10)
+		case "runtime":
+			c.Key = strconv.FormatInt(int64(movies[i].Runtime), 10)
+		case "relevance":
+			c.Key = strconv.FormatFloat(relevances[i], 'g', -1, 64)
+		default:
+			c.Key = strconv.FormatInt(movies[i].ID, 10)
+		}
+
+		return c.encode(f.CursorKey)
+	}
+
+	if hasNext {
+		metadata.NextCursor = at(len(movies)-1, false)
+	}
+
+	if hasPrev {
+		metadata.PrevCursor = at(0, true)
+	}
 }
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// The change events written to the outbox.
+const (
+	EventMovieCreated = "movie.created"
+	EventMovieUpdated = "movie.updated"
+	EventMovieDeleted = "movie.deleted"
+)
+
+// A change event from the outbox; the ID increases with every event.
+type OutboxEvent struct {
+	ID        int64
+	CreatedAt time.Time
+	Event     string
+	Payload   json.RawMessage // e.g. {"movie": {...}}
+}
+
+// An arbitrary key for the advisory lock serializing the outbox writers.
+const outboxLockKey = 7_402_731
+
+// A change event made by a transaction, written to the outbox as it commits (see commitOutbox).
+type outboxEntry struct {
+	event   string
+	payload []byte
+}
+
+// The payload is encoded right away, so later changes to it (e.g. further updates of the same
+// movie in a batch) don't leak into the event.
+func newOutboxEntry(event string, payload any) (outboxEntry, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return outboxEntry{}, err
+	}
+
+	return outboxEntry{event: event, payload: js}, nil
+}
+
+// Writes the transaction's change events & commits it, so the events are recorded if & only if
+// the changes are.
+//
+// The ids come from a sequence, which hands them out before the commit; a transaction committing
+// later with a smaller id could slip behind a relay's checkpoint. So the events are only written
+// at the very end, under a lock held until the commit: they become visible in id order, while
+// the writers are only serialized for the moment it takes to commit (not for the whole transaction).
+func commitOutbox(tx *sql.Tx, entries ...outboxEntry) error {
+	if len(entries) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", outboxLockKey)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			_, err = tx.ExecContext(ctx, "INSERT INTO outbox_events (event, payload) VALUES ($1, $2)", e.event, e.payload)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The transaction of a durable subscriber's round. Whatever a handler writes through it is committed
+// together with the checkpoint moving past the event, or not at all (see Process).
+type OutboxTx struct {
+	ctx context.Context
+	tx  *sql.Tx
+}
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// The id of the most recent event (0 if there's none).
+func (m OutboxModel) LatestID() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM outbox_events").Scan(&id)
+
+	return id, err
+}
+
+// Up to limit events after the given id, oldest first.
+func (m OutboxModel) GetAfter(id int64, limit int) ([]*OutboxEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getOutboxEvents(ctx, m.DB, id, limit)
+}
+
+func getOutboxEvents(ctx context.Context, db interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}, after int64, limit int) ([]*OutboxEvent, error) {
+	q := `SELECT id, created_at, event, payload
+	FROM outbox_events
+	WHERE id > $1
+	ORDER BY id
+	LIMIT $2`
+
+	rows, err := db.QueryContext(ctx, q, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*OutboxEvent
+
+	for rows.Next() {
+		var e OutboxEvent
+
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Event, &e.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Hands the next (up to limit) events after the subscriber's checkpoint to handle, in order,
+// & moves the checkpoint past the handled ones. The first error stops the batch; the failed
+// event is handed out again on the next call.
+//
+// The handler gets the transaction moving the checkpoint: work it records through it (e.g. the
+// webhook deliveries to make) is committed with the checkpoint, so it's never lost nor recorded twice.
+// What a failing handler wrote is rolled back.
+//
+// The checkpoint row stays locked meanwhile, so with several API instances each event goes to
+// one of them; an instance finding the row locked skips the round (handled = 0, err = nil).
+// A new subscriber starts with the events written after its first call.
+//
+// N.B. if the process dies before the checkpoint is committed, the batch is handled once more;
+// side effects outside the transaction should tolerate that.
+func (m OutboxModel) Process(subscriber string, limit int, handle func(*OutboxTx, *OutboxEvent) error) (handled int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, `INSERT INTO outbox_checkpoints (subscriber, last_event_id)
+	SELECT $1, COALESCE(MAX(id), 0) FROM outbox_events
+	ON CONFLICT (subscriber) DO NOTHING`, subscriber)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var checkpoint int64
+
+	err = tx.QueryRowContext(ctx, `SELECT last_event_id
+	FROM outbox_checkpoints
+	WHERE subscriber = $1
+	FOR UPDATE SKIP LOCKED`, subscriber).Scan(&checkpoint)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Another instance is on it.
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+
+	events, err := getOutboxEvents(ctx, tx, checkpoint, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	otx := &OutboxTx{ctx: ctx, tx: tx}
+
+	var handleErr error
+
+	for _, e := range events {
+		_, err = tx.ExecContext(ctx, "SAVEPOINT outbox_event")
+		if err != nil {
+			return 0, err
+		}
+
+		handleErr = handle(otx, e)
+		if handleErr != nil {
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT outbox_event")
+			if err != nil {
+				return 0, err
+			}
+			break
+		}
+
+		checkpoint = e.ID
+		handled++
+	}
+
+	if handled > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE outbox_checkpoints
+		SET last_event_id = $2, updated_at = NOW()
+		WHERE subscriber = $1`, subscriber, checkpoint)
+		if err != nil {
+			return 0, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return handled, handleErr
+}
+
+// Deletes the events older than the retention period.
+func (m OutboxModel) DeleteOlderThan(retention time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM outbox_events WHERE created_at < $1", time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestGenreMergeEvents(t *testing.T) {
+	// The stub database retags movies 3 & 5.
+	db := &stubDB{retagged: []int64{3, 5}}
+
+	genres := GenreModel{DB: sql.OpenDB(db)}
+	defer genres.DB.Close()
+
+	err := genres.Merge(&Genre{ID: 2, Slug: "sci-fi"}, &Genre{ID: 1, Slug: "science-fiction"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !db.committed {
+		t.Fatal("the transaction wasn't committed")
+	}
+
+	if len(db.events) != 2 {
+		t.Fatalf("%d outbox events; want 2", len(db.events))
+	}
+
+	for i, id := range db.retagged {
+		event := db.events[i]
+
+		if event.event != EventMovieUpdated {
+			t.Errorf("event %d is %q; want %q", i, event.event, EventMovieUpdated)
+		}
+
+		var payload struct {
+			Movie struct {
+				ID            int64   `json:"id"`
+				AverageRating float64 `json:"average_rating"`
+			} `json:"movie"`
+		}
+
+		err := json.Unmarshal(event.payload, &payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if payload.Movie.ID != id || payload.Movie.AverageRating != 4.5 {
+			t.Errorf("event %d is about movie %d (rated %v); want movie %d (rated 4.5)", i, payload.Movie.ID, payload.Movie.AverageRating, id)
+		}
+	}
+}
+
+func TestGenreRenameEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		newSlug string
+		events  int
+	}{
+		{name: "new name only", newSlug: "sci-fi", events: 0},
+		{name: "new slug", newSlug: "science-fiction", events: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &stubDB{retagged: []int64{3, 5}}
+
+			genres := GenreModel{DB: sql.OpenDB(db)}
+			defer genres.DB.Close()
+
+			err := genres.Rename("sci-fi", &Genre{ID: 1, Slug: tt.newSlug, Name: "Science Fiction"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !db.committed {
+				t.Fatal("the transaction wasn't committed")
+			}
+
+			if len(db.events) != tt.events {
+				t.Errorf("%d outbox events; want %d", len(db.events), tt.events)
+			}
+		})
+	}
+}
+
+// A database/sql driver standing in for Postgres in the genre transactions: the genre
+// replacement retags the given movies, which read back as rated 4.5; the outbox inserts are recorded.
+// For the duplicate queries, pairs are the likely duplicates & deleted the movies which are gone.
+type stubDB struct {
+	retagged  []int64
+	events    []outboxEntry
+	committed bool
+	pairs     [][2]int64
+	deleted   map[int64]bool
+	queries   []stubQuery
+}
+
+type stubQuery struct {
+	query string
+	args  []any
+}
+
+func (db *stubDB) Connect(context.Context) (driver.Conn, error) { return stubConn{db}, nil }
+func (db *stubDB) Driver() driver.Driver                        { return nil }
+
+type stubConn struct {
+	db *stubDB
+}
+
+func (c stubConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (c stubConn) Close() error                        { return nil }
+func (c stubConn) Begin() (driver.Tx, error)           { return stubTx(c), nil }
+
+func (c stubConn) ExecContext(_ context.Context, q string, args []driver.NamedValue) (driver.Result, error) {
+	if strings.HasPrefix(q, "INSERT INTO outbox_events") {
+		c.db.events = append(c.db.events, outboxEntry{event: args[0].Value.(string), payload: args[1].Value.([]byte)})
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c stubConn) QueryContext(_ context.Context, q string, args []driver.NamedValue) (driver.Rows, error) {
+	query := stubQuery{query: q}
+	for _, arg := range args {
+		query.args = append(query.args, arg.Value)
+	}
+	c.db.queries = append(c.db.queries, query)
+
+	switch {
+	case strings.HasPrefix(q, "SELECT a.id, b.id"):
+		rows := &stubRows{columns: []string{"a", "b"}}
+		for _, pair := range c.db.pairs {
+			rows.rows = append(rows.rows, []driver.Value{pair[0], pair[1]})
+		}
+		return rows, nil
+
+	case strings.Contains(q, "WHERE id = ANY($1)"):
+		// The ids arrive as an array literal, e.g. "{1,4,7}" (or NULL without any).
+		rows := &stubRows{}
+		ids, _ := args[0].Value.(string)
+		for _, s := range strings.FieldsFunc(strings.Trim(ids, "{}"), func(r rune) bool { return r == ',' }) {
+			id, _ := strconv.ParseInt(s, 10, 64)
+			if !c.db.deleted[id] {
+				row := movieRow(id)
+				rows.columns = row.columns
+				rows.rows = append(rows.rows, row.rows...)
+			}
+		}
+		return rows, nil
+
+	case strings.Contains(q, "WITH updated AS"):
+		rows := &stubRows{columns: []string{"id"}}
+		for _, id := range c.db.retagged {
+			rows.rows = append(rows.rows, []driver.Value{id})
+		}
+		return rows, nil
+
+	case strings.Contains(q, "WHERE id = $1"):
+		return movieRow(args[0].Value.(int64)), nil
+
+	case strings.HasPrefix(q, "SELECT EXISTS"):
+		return &stubRows{columns: []string{"exists"}, rows: [][]driver.Value{{false}}}, nil
+
+	default:
+		return &stubRows{}, nil
+	}
+}
+
+// A full row of the movie (as read by getMovie), whatever the columns are.
+func movieRow(id int64) *stubRows {
+	rows := &stubRows{rows: [][]driver.Value{nil}}
+
+	for _, column := range movieColumnsFor(nil, "''").columns {
+		var value driver.Value
+
+		switch column.dest(&Movie{}).(type) {
+		case *int64:
+			value = id
+		case *time.Time:
+			value = time.Now()
+		case *string:
+			value = "Alien"
+		case *int32, *Runtime:
+			value = int64(117)
+		case *float64:
+			value = 4.5
+		case *pq.StringArray:
+			value = []byte("{science-fiction}")
+		default:
+			value = nil // e.g. no poster
+		}
+
+		rows.columns = append(rows.columns, column.expr)
+		rows.rows[0] = append(rows.rows[0], value)
+	}
+
+	return rows
+}
+
+type stubTx stubConn
+
+func (tx stubTx) Commit() error {
+	tx.db.committed = true
+	return nil
+}
+
+func (tx stubTx) Rollback() error { return nil }
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
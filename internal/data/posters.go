@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Where the API serves the posters; a poster's URL is this followed by its storage key.
+const PosterURLPrefix = "/v1/posters/"
+
+// The widths (in pixels) of the thumbnails generated for every poster, e.g. "w185".
+var PosterThumbnailWidths = []int{185, 500}
+
+// The storage key of a poster's thumbnail, e.g. "12/9f86d081884c7d65-1a2b3c4d_w185.jpg";
+// the thumbnails are always JPEGs.
+func PosterThumbnailKey(key string, width int) string {
+	return fmt.Sprintf("%s_w%d.jpg", strings.TrimSuffix(key, path.Ext(key)), width)
+}
+
+// Sets the poster & the URLs derived from it.
+func (movie *Movie) setPoster(key string) {
+	movie.Poster = key
+	movie.PosterURL = ""
+	movie.PosterThumbnailURLs = nil
+
+	if key == "" {
+		return
+	}
+
+	movie.PosterURL = PosterURLPrefix + key
+	movie.PosterThumbnailURLs = make(map[string]string, len(PosterThumbnailWidths))
+
+	for _, width := range PosterThumbnailWidths {
+		movie.PosterThumbnailURLs[fmt.Sprintf("w%d", width)] = PosterURLPrefix + PosterThumbnailKey(key, width)
+	}
+}
+
+// Scans the poster column into a movie, along with the URLs.
+type posterScanner struct {
+	movie *Movie
+}
+
+func (p posterScanner) Scan(src any) error {
+	var key sql.NullString
+
+	err := key.Scan(src)
+	if err != nil {
+		return err
+	}
+
+	p.movie.setPoster(key.String)
+
+	return nil
+}
+
+// Replaces the movie's poster (or removes it, for "") if it's still the previous one, the
+// caller read: returns ErrEditConflict if it was changed meanwhile (e.g. by a concurrent upload).
+// So the previous poster is known to be unused once this succeeds, & the new one when it doesn't.
+//
+// The version isn't incremented: the poster isn't part of the movie's history.
+// The change is sent out as a movie.updated event.
+func (m MovieModel) SetPoster(id int64, previous, key string) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE movies SET poster = $1 WHERE id = $2 AND poster = $3", key, id, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		var exists bool
+
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM movies WHERE id = $1)", id).Scan(&exists)
+		switch {
+		case err != nil:
+			return nil, err
+		case exists:
+			return nil, ErrEditConflict
+		default:
+			return nil, ErrRecordNotFound
+		}
+	}
+
+	movie, err := getMovie(tx, id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := newOutboxEntry(EventMovieUpdated, map[string]any{"movie": movie})
+	if err != nil {
+		return nil, err
+	}
+
+	err = commitOutbox(tx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	m.cache.set(movie)
+
+	return movie, nil
+}
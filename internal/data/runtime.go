@@ -1,8 +1,10 @@
 package data
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 // Declare a custom `Runtime` type.
@@ -20,3 +22,18 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 	// Otherwise it won't be detected as a valid "JSON string".
 	return []byte(strconv.Quote(jsonVal)), nil
 }
+
+var ErrInvalidRuntimeFormat = errors.New(`invalid runtime format; expected e.g. "102" or "102 mins"`)
+
+// Parses a runtime in the form the API emits ("102 mins"); a plain number of minutes is fine too.
+func ParseRuntime(s string) (Runtime, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(strings.TrimSuffix(s, "mins"))
+
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return Runtime(i), nil
+}
@@ -0,0 +1,207 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+	"github.com/lib/pq"
+)
+
+// A movie's title (& optionally its overview) in another language.
+type MovieTranslation struct {
+	MovieID  int64  `json:"movie_id"`
+	Locale   string `json:"locale"` // e.g. "de" or "pt-BR"
+	Title    string `json:"title"`
+	Overview string `json:"overview,omitempty"`
+	Version  int32  `json:"version"`
+}
+
+// A language, optionally followed by a region, e.g. "de", "pt-BR" or "es-419".
+var LocaleRX = regexp.MustCompile(`^[a-z]{2,3}(-([A-Z]{2}|[0-9]{3}))?$`)
+
+// Puts a language tag into its canonical form, e.g. "pt_br" => "pt-BR" & "DE" => "de".
+func NormalizeLocale(locale string) string {
+	language, region, found := strings.Cut(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"), "-")
+
+	locale = strings.ToLower(language)
+	if found {
+		locale += "-" + strings.ToUpper(region)
+	}
+
+	return locale
+}
+
+// Validates a (normalized) language tag; key is the field to report errors on.
+func ValidateLocale(v *validator.Validator, key, locale string) {
+	v.Check(locale != "", key, "must be provided")
+	v.Check(validator.Matches(locale, LocaleRX), key, "must be a language tag like de or pt-BR")
+}
+
+func ValidateTranslation(v *validator.Validator, translation *MovieTranslation) {
+	ValidateLocale(v, "locale", translation.Locale)
+
+	v.Check(translation.Title != "", "title", "must be provided")
+	v.Check(len(translation.Title) <= 500, "title", "must not be more than 500 bytes long")
+
+	v.Check(len(translation.Overview) <= 5000, "overview", "must not be more than 5000 bytes long")
+}
+
+type TranslationModel struct {
+	DB *sql.DB
+}
+
+// Adds the translation, or replaces the existing one for the same locale (created reports which).
+// Returns ErrRecordNotFound if the movie doesn't exist.
+func (m TranslationModel) Upsert(translation *MovieTranslation) (created bool, err error) {
+	// xmax is 0 for a freshly inserted row & set for an updated one.
+	q := `INSERT INTO movie_translations (movie_id, locale, title, overview)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (movie_id, locale) DO UPDATE
+	SET title = EXCLUDED.title, overview = EXCLUDED.overview, version = movie_translations.version + 1
+	RETURNING version, xmax = 0`
+
+	args := []any{translation.MovieID, translation.Locale, translation.Title, translation.Overview}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, q, args...).Scan(&translation.Version, &created)
+	if err != nil {
+		switch {
+		case violatesConstraint(err, foreignKeyViolation, "movie_translations_movie_id_fkey"):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	return created, nil
+}
+
+// The translations of a movie, by locale.
+func (m TranslationModel) GetAllForMovie(movieID int64) ([]*MovieTranslation, error) {
+	q := `SELECT movie_id, locale, title, overview, version
+	FROM movie_translations
+	WHERE movie_id = $1
+	ORDER BY locale`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := []*MovieTranslation{}
+
+	for rows.Next() {
+		var t MovieTranslation
+
+		err := rows.Scan(&t.MovieID, &t.Locale, &t.Title, &t.Overview, &t.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		translations = append(translations, &t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+// Picks the best translation of each movie: the one in the earliest of the locales (given in
+// order of preference). Movies without a translation into any of them are left out.
+func (m TranslationModel) GetBest(movieIDs []int64, locales []string) (map[int64]*MovieTranslation, error) {
+	translations := make(map[int64]*MovieTranslation)
+
+	if len(movieIDs) == 0 || len(locales) == 0 {
+		return translations, nil
+	}
+
+	q := `SELECT DISTINCT ON (movie_id) movie_id, locale, title, overview, version
+	FROM movie_translations
+	WHERE movie_id = ANY($1) AND locale = ANY($2)
+	ORDER BY movie_id, array_position($2, locale)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q, pq.Array(movieIDs), pq.Array(locales))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t MovieTranslation
+
+		err := rows.Scan(&t.MovieID, &t.Locale, &t.Title, &t.Overview, &t.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		translations[t.MovieID] = &t
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
+
+func (m TranslationModel) Delete(movieID int64, locale string) error {
+	q := "DELETE FROM movie_translations WHERE movie_id = $1 AND locale = $2"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, q, movieID, locale)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Replaces the titles of the movies with their best translation into the locales (see GetBest),
+// keeping the original in OriginalTitle.
+func (m TranslationModel) Localize(movies []*Movie, locales []string) error {
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	translations, err := m.GetBest(ids, locales)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		if t, ok := translations[movie.ID]; ok {
+			movie.OriginalTitle = movie.Title
+			movie.Title = t.Title
+			movie.TitleLocale = t.Locale
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+	"github.com/lib/pq"
+)
+
+// A movie saved by a user to watch later.
+type WatchlistItem struct {
+	UserID    int64     `json:"-"`
+	MovieID   int64     `json:"-"`
+	Movie     *Movie    `json:"movie,omitempty"`
+	AddedAt   time.Time `json:"added_at"`
+	Watched   bool      `json:"watched"`
+	WatchedAt *Date     `json:"watched_at"` // null unless watched
+}
+
+func ValidateWatchlistItem(v *validator.Validator, item *WatchlistItem) {
+	if item.WatchedAt != nil {
+		v.Check(item.Watched, "watched_at", "must be empty unless the movie is watched")
+		v.Check(!time.Time(*item.WatchedAt).After(time.Now()), "watched_at", "must not be in the future")
+	}
+}
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Fetches a single item (without the movie details).
+func (m WatchlistModel) Get(userID, movieID int64) (*WatchlistItem, error) {
+	q := `SELECT user_id, movie_id, added_at, watched, watched_at
+	FROM watchlist_items
+	WHERE user_id = $1 AND movie_id = $2`
+
+	var item WatchlistItem
+	var watchedAt sql.NullTime
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, q, userID, movieID).Scan(
+		&item.UserID,
+		&item.MovieID,
+		&item.AddedAt,
+		&item.Watched,
+		&watchedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if watchedAt.Valid {
+		d := Date(watchedAt.Time)
+		item.WatchedAt = &d
+	}
+
+	return &item, nil
+}
+
+// Adds the item to the watchlist or overwrites the existing one.
+// created reports whether the movie was newly added.
+func (m WatchlistModel) Upsert(item *WatchlistItem) (created bool, err error) {
+	// N.B. xmax is 0 only for freshly inserted rows.
+	q := `INSERT INTO watchlist_items (user_id, movie_id, watched, watched_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (user_id, movie_id) DO UPDATE
+	SET watched = EXCLUDED.watched, watched_at = EXCLUDED.watched_at
+	RETURNING added_at, (xmax = 0)`
+
+	var watchedAt any
+	if item.WatchedAt != nil {
+		watchedAt = time.Time(*item.WatchedAt)
+	}
+
+	args := []any{item.UserID, item.MovieID, item.Watched, watchedAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, q, args...).Scan(&item.AddedAt, &created)
+	if err != nil {
+		switch {
+		case violatesConstraint(err, foreignKeyViolation, "watchlist_items_movie_id_fkey"):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	return created, nil
+}
+
+func (m WatchlistModel) Delete(userID, movieID int64) error {
+	q := "DELETE FROM watchlist_items WHERE user_id = $1 AND movie_id = $2"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, q, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Lists a user's watchlist, including the movie details.
+// A nil watched pointer returns both watched & unwatched items.
+func (m WatchlistModel) GetAll(userID int64, watched *bool, filters Filters) ([]*WatchlistItem, Metadata, error) {
+	q := fmt.Sprintf(`SELECT count(*) OVER(), watchlist_items.added_at, watchlist_items.watched, watchlist_items.watched_at,
+		movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+		movies.poster, r.average_rating, r.rating_count
+	FROM watchlist_items
+	INNER JOIN movies ON movies.id = watchlist_items.movie_id
+	`+ratingsJoin+`
+	WHERE watchlist_items.user_id = $1
+	AND ($2::boolean IS NULL OR watchlist_items.watched = $2)
+	ORDER BY %s %s NULLS LAST, movies.id ASC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	var watchedArg any
+	if watched != nil {
+		watchedArg = *watched
+	}
+
+	args := []any{userID, watchedArg, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*WatchlistItem{}
+
+	for rows.Next() {
+		var item WatchlistItem
+		var movie Movie
+		var watchedAt sql.NullTime
+
+		err := rows.Scan(
+			&totalRecords,
+			&item.AddedAt,
+			&item.Watched,
+			&watchedAt,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			posterScanner{&movie},
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if watchedAt.Valid {
+			d := Date(watchedAt.Time)
+			item.WatchedAt = &d
+		}
+
+		item.UserID = userID
+		item.MovieID = movie.ID
+		item.Movie = &movie
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return items, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,446 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/heschmat/go_movies_api_rest/internal/validator"
+	"github.com/lib/pq"
+)
+
+// The events a webhook can subscribe to.
+var WebhookEvents = []string{EventMovieCreated, EventMovieUpdated, EventMovieDeleted}
+
+// A subscription: the events are POSTed to the URL, signed with the secret.
+// N.B. the secret is never encoded in JSON responses.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	// Webhooks are disabled automatically after too many failed events in a row.
+	Active              bool       `json:"active"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+	Version             int32      `json:"version"`
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	v.Check(len(webhook.Events) >= 1, "events", "must contain at least 1 event")
+
+	seen := make(map[string]bool)
+	for _, event := range webhook.Events {
+		v.Check(validator.PermittedValue(event, WebhookEvents...), "events", "must only contain "+strings.Join(WebhookEvents, ", "))
+		v.Check(!seen[event], "events", "must not contain duplicate values")
+		seen[event] = true
+	}
+
+	// The secret is the HMAC key; a short one would be easy to guess.
+	v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 bytes long")
+	v.Check(len(webhook.Secret) <= 200, "secret", "must not be more than 200 bytes long")
+}
+
+// One attempt at delivering an event to a webhook.
+type WebhookDelivery struct {
+	ID          int64     `json:"id"`
+	WebhookID   int64     `json:"webhook_id"`
+	EventID     string    `json:"event_id"`
+	Event       string    `json:"event"`
+	Attempt     int       `json:"attempt"` // 1 for the first try
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  *int      `json:"status_code"` // null if no response was received
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int       `json:"duration_ms"`
+	Succeeded   bool      `json:"succeeded"` // a 2xx response
+}
+
+// A delivery still to be made (see QueueDeliveries), with what's needed to make it.
+type PendingDelivery struct {
+	ID        int64
+	WebhookID int64
+	EventID   string
+	Event     string
+	Body      []byte
+	Attempts  int // made so far
+
+	URL    string
+	Secret string
+	Active bool // false if the webhook was disabled since the event was queued
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+const webhookColumns = `id, created_at, user_id, url, events, secret, active, consecutive_failures, disabled_at, version`
+
+func scanWebhook(row interface{ Scan(...any) error }) (*Webhook, error) {
+	var webhook Webhook
+	var disabledAt sql.NullTime
+
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		pq.Array(&webhook.Events),
+		&webhook.Secret,
+		&webhook.Active,
+		&webhook.ConsecutiveFailures,
+		&disabledAt,
+		&webhook.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if disabledAt.Valid {
+		webhook.DisabledAt = &disabledAt.Time
+	}
+
+	return &webhook, nil
+}
+
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	q := `INSERT INTO webhooks (user_id, url, events, secret)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, active, version`
+
+	args := []any{webhook.UserID, webhook.URL, pq.Array(webhook.Events), webhook.Secret}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, q, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Active, &webhook.Version)
+}
+
+// Fetches a webhook of the given user; other users' webhooks are reported as not found.
+func (m WebhookModel) GetForUser(id, userID int64) (*Webhook, error) {
+	q := `SELECT ` + webhookColumns + `
+	FROM webhooks
+	WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	webhook, err := scanWebhook(m.DB.QueryRowContext(ctx, q, id, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return webhook, nil
+}
+
+func (m WebhookModel) GetAllForUser(userID int64) ([]*Webhook, error) {
+	q := `SELECT ` + webhookColumns + `
+	FROM webhooks
+	WHERE user_id = $1
+	ORDER BY id`
+
+	return m.query(q, userID)
+}
+
+func (m WebhookModel) query(q string, args ...any) ([]*Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Optimistic locking, as for movies. Re-activating a webhook resets its failure count;
+// the failures themselves are only ever recorded by RecordResult.
+func (m WebhookModel) Update(webhook *Webhook) error {
+	q := `UPDATE webhooks
+	SET url = $1, events = $2, secret = $3, active = $4,
+		consecutive_failures = CASE WHEN $4 AND NOT active THEN 0 ELSE consecutive_failures END,
+		disabled_at = CASE WHEN $4 THEN NULL ELSE COALESCE(disabled_at, NOW()) END,
+		version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING consecutive_failures, disabled_at, version`
+
+	args := []any{webhook.URL, pq.Array(webhook.Events), webhook.Secret, webhook.Active, webhook.ID, webhook.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var disabledAt sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, q, args...).Scan(&webhook.ConsecutiveFailures, &disabledAt, &webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	webhook.DisabledAt = nil
+	if disabledAt.Valid {
+		webhook.DisabledAt = &disabledAt.Time
+	}
+
+	return nil
+}
+
+// Deletes a webhook of the given user, together with its delivery log.
+func (m WebhookModel) Delete(id, userID int64) error {
+	q := "DELETE FROM webhooks WHERE id = $1 AND user_id = $2"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, q, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Records the outcome of an event once all its delivery attempts are done.
+// A success resets the failure count; after disableAfter failed events in a row the webhook
+// is disabled (disabled reports whether this call did so).
+func (m WebhookModel) RecordResult(id int64, succeeded bool, disableAfter int) (disabled bool, err error) {
+	q := `UPDATE webhooks
+	SET consecutive_failures = 0
+	WHERE id = $1
+	RETURNING false`
+
+	args := []any{id}
+
+	if !succeeded {
+		// N.B. the SET expressions see the row as it was before the update,
+		// RETURNING sees the updated row; the old state comes from the locked subquery.
+		q = `UPDATE webhooks
+		SET consecutive_failures = webhooks.consecutive_failures + 1,
+			active = webhooks.active AND webhooks.consecutive_failures + 1 < $2,
+			disabled_at = CASE WHEN webhooks.active AND webhooks.consecutive_failures + 1 >= $2 THEN NOW() ELSE webhooks.disabled_at END
+		FROM (SELECT id, active FROM webhooks WHERE id = $1 FOR UPDATE) AS old
+		WHERE webhooks.id = old.id
+		RETURNING old.active AND NOT webhooks.active`
+
+		args = append(args, disableAfter)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, q, args...).Scan(&disabled)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Deleted in the meantime.
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return disabled, nil
+}
+
+func (m WebhookModel) InsertDelivery(delivery *WebhookDelivery) error {
+	q := `INSERT INTO webhook_deliveries (webhook_id, event_id, event, attempt, status_code, error, duration_ms)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, attempted_at`
+
+	args := []any{delivery.WebhookID, delivery.EventID, delivery.Event, delivery.Attempt, delivery.StatusCode, delivery.Error, delivery.DurationMS}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, q, args...).Scan(&delivery.ID, &delivery.AttemptedAt)
+	if err != nil {
+		// e.g. the webhook was deleted in the meantime.
+		return fmt.Errorf("recording webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+// The delivery log of a webhook, sorted by "id" (oldest first) or "-id" (newest first).
+func (m WebhookModel) GetDeliveries(webhookID int64, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	q := fmt.Sprintf(`SELECT count(*) OVER(), id, webhook_id, event_id, event, attempt, attempted_at, status_code, error, duration_ms
+	FROM webhook_deliveries
+	WHERE webhook_id = $1
+	ORDER BY %s %s
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q, webhookID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var statusCode sql.NullInt32
+
+		err := rows.Scan(
+			&totalRecords,
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.EventID,
+			&delivery.Event,
+			&delivery.Attempt,
+			&delivery.AttemptedAt,
+			&statusCode,
+			&delivery.Error,
+			&delivery.DurationMS,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if statusCode.Valid {
+			code := int(statusCode.Int32)
+			delivery.StatusCode = &code
+			delivery.Succeeded = code >= 200 && code <= 299
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return deliveries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Queues a delivery of the event to every active webhook subscribed to it, as part of the outbox
+// subscriber's round: the deliveries are recorded if & only if the checkpoint moves past the event.
+// Queueing the same event again changes nothing.
+func (m WebhookModel) QueueDeliveries(otx *OutboxTx, eventID, event string, body []byte) error {
+	q := `INSERT INTO webhook_pending_deliveries (webhook_id, event_id, event, body)
+	SELECT id, $1, $2, $3
+	FROM webhooks
+	WHERE active AND events @> ARRAY[$2]
+	ON CONFLICT (webhook_id, event_id) DO NOTHING`
+
+	_, err := otx.tx.ExecContext(otx.ctx, q, eventID, event, body)
+	return err
+}
+
+// Claims up to limit of the deliveries which are due, oldest first. They aren't due again
+// until the lease is over, so no other instance attempts them meanwhile; a claim outliving
+// its process (e.g. after a crash) simply expires.
+func (m WebhookModel) ClaimDeliveries(limit int, lease time.Duration) ([]*PendingDelivery, error) {
+	q := `UPDATE webhook_pending_deliveries AS p
+	SET next_attempt_at = NOW() + make_interval(secs => $2)
+	FROM webhooks AS w
+	WHERE w.id = p.webhook_id AND p.id IN (
+		SELECT id
+		FROM webhook_pending_deliveries
+		WHERE next_attempt_at <= NOW()
+		ORDER BY next_attempt_at, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING p.id, p.webhook_id, p.event_id, p.event, p.body, p.attempts, w.url, w.secret, w.active`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, q, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*PendingDelivery
+
+	for rows.Next() {
+		var d PendingDelivery
+
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Body, &d.Attempts, &d.URL, &d.Secret, &d.Active)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Removes a delivery from the queue: it succeeded, or it's given up on.
+func (m WebhookModel) FinishDelivery(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM webhook_pending_deliveries WHERE id = $1", id)
+	return err
+}
+
+// Records a failed attempt; the delivery is due again after the delay.
+func (m WebhookModel) RetryDelivery(id int64, attempts int, delay time.Duration) error {
+	q := `UPDATE webhook_pending_deliveries
+	SET attempts = $2, next_attempt_at = NOW() + make_interval(secs => $3)
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, q, id, attempts, delay.Seconds())
+	return err
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Put("12/poster.jpg", strings.NewReader("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaces the existing file.
+	err = l.Put("12/poster.jpg", strings.NewReader("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := l.Open("12/poster.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "second" || obj.Size != int64(len("second")) {
+		t.Errorf("got %q (size %d); want %q", body, obj.Size, "second")
+	}
+
+	err = l.DeleteAll("12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = l.Open("12/poster.jpg")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v after DeleteAll; want ErrNotFound", err)
+	}
+
+	// Deleting a missing file is fine.
+	err = l.Delete("12/poster.jpg")
+	if err != nil {
+		t.Errorf("got %v deleting a missing file; want nil", err)
+	}
+
+	for _, key := range []string{"", ".", "../etc/passwd", "/etc/passwd", "12/../../x", `12\x.jpg`, "12/"} {
+		if _, err := l.Open(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Open(%q): got %v; want ErrInvalidKey", key, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Package storage keeps uploaded files, e.g. the movie posters.
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: object not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Where the files are kept; the local filesystem for now (see Local), an object store later.
+//
+// Files are addressed by keys: slash-separated relative paths like "12/9f86d081884c7d65.jpg".
+// A "directory" is simply a key prefix, e.g. "12" for the files of movie 12.
+type Storage interface {
+	// Stores the body under the key, replacing any existing file; the file is only visible once complete.
+	Put(key string, body io.Reader) error
+	// The caller must close the returned object. Returns ErrNotFound for a missing key.
+	Open(key string) (*Object, error)
+	// Deleting a missing key is not an error.
+	Delete(key string) error
+	// Deletes every file under the directory, e.g. "12" deletes "12/9f86d081884c7d65.jpg".
+	DeleteAll(dir string) error
+}
+
+// A stored file opened for reading.
+type Object struct {
+	io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+}
+
+// Keys must be clean relative paths, which can't escape the storage root: no "..", no leading
+// or trailing slash & no backslashes.
+func ValidKey(key string) bool {
+	return key != "." && fs.ValidPath(key) && !strings.Contains(key, `\`)
+}
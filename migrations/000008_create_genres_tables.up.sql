@@ -0,0 +1,81 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text UNIQUE NOT NULL,
+    name text NOT NULL
+);
+
+-- Alternative spellings which resolve to a canonical genre (stored as slugs as well).
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    alias text PRIMARY KEY,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE
+);
+
+INSERT INTO genres (slug, name) VALUES
+    ('action', 'Action'),
+    ('adventure', 'Adventure'),
+    ('animation', 'Animation'),
+    ('biography', 'Biography'),
+    ('comedy', 'Comedy'),
+    ('crime', 'Crime'),
+    ('documentary', 'Documentary'),
+    ('drama', 'Drama'),
+    ('family', 'Family'),
+    ('fantasy', 'Fantasy'),
+    ('history', 'History'),
+    ('horror', 'Horror'),
+    ('musical', 'Musical'),
+    ('mystery', 'Mystery'),
+    ('romance', 'Romance'),
+    ('sci-fi', 'Science Fiction'),
+    ('sport', 'Sport'),
+    ('thriller', 'Thriller'),
+    ('war', 'War'),
+    ('western', 'Western')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO genre_aliases (alias, genre_id)
+SELECT a.alias, genres.id
+FROM (VALUES
+    ('science-fiction', 'sci-fi'),
+    ('scifi', 'sci-fi'),
+    ('sports', 'sport'),
+    ('biopic', 'biography'),
+    ('animated', 'animation')
+) AS a(alias, slug)
+INNER JOIN genres ON genres.slug = a.slug
+ON CONFLICT DO NOTHING;
+
+-- Every free-form genre already in use which doesn't resolve yet becomes a genre of its own.
+-- Spelling variants can then be merged by an admin through the API.
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (s.slug) s.slug, s.name
+FROM (
+    SELECT trim(both '-' from regexp_replace(lower(g), '[^a-z0-9]+', '-', 'g')) AS slug, trim(g) AS name
+    FROM movies, unnest(genres) AS g
+) s
+WHERE s.slug <> ''
+AND NOT EXISTS (SELECT 1 FROM genre_aliases WHERE genre_aliases.alias = s.slug)
+ORDER BY s.slug, s.name
+ON CONFLICT DO NOTHING;
+
+-- Rewrite the existing movies to use canonical slugs (order preserving & without duplicates).
+WITH resolved AS (
+    SELECT movies.id, t.ord, COALESCE(genres.slug, alias_genres.slug) AS slug
+    FROM movies
+    CROSS JOIN LATERAL unnest(movies.genres) WITH ORDINALITY AS t(name, ord)
+    LEFT JOIN genres
+        ON genres.slug = trim(both '-' from regexp_replace(lower(t.name), '[^a-z0-9]+', '-', 'g'))
+    LEFT JOIN genre_aliases
+        ON genre_aliases.alias = trim(both '-' from regexp_replace(lower(t.name), '[^a-z0-9]+', '-', 'g'))
+    LEFT JOIN genres AS alias_genres ON alias_genres.id = genre_aliases.genre_id
+), firsts AS (
+    SELECT id, slug, min(ord) AS ord
+    FROM resolved
+    WHERE slug IS NOT NULL
+    GROUP BY id, slug
+)
+UPDATE movies
+SET genres = normalized.genres
+FROM (SELECT id, array_agg(slug ORDER BY ord) AS genres FROM firsts GROUP BY id) AS normalized
+WHERE movies.id = normalized.id;
@@ -0,0 +1,12 @@
+-- Items disappear automatically when either the user or the movie is deleted.
+CREATE TABLE IF NOT EXISTS watchlist_items (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watched boolean NOT NULL DEFAULT false,
+    watched_at date,
+    PRIMARY KEY (user_id, movie_id),
+    CONSTRAINT watchlist_items_watched_at_check CHECK (watched OR watched_at IS NULL)
+);
+
+CREATE INDEX IF NOT EXISTS watchlist_items_movie_id_idx ON watchlist_items (movie_id);
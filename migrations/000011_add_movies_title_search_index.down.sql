@@ -0,0 +1 @@
+DROP INDEX IF EXISTS movies_title_tsv_idx;
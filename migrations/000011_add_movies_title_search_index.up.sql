@@ -0,0 +1,2 @@
+-- Must match the expression used by GetMovies exactly, otherwise the index is not used.
+CREATE INDEX IF NOT EXISTS movies_title_tsv_idx ON movies USING GIN (to_tsvector('simple', title));
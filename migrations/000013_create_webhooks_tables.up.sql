@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    url text NOT NULL,
+    events text[] NOT NULL,
+    -- The key for signing the deliveries; it must be readable, so it's NOT hashed.
+    secret text NOT NULL,
+    active boolean NOT NULL DEFAULT true,
+    -- Events whose every delivery attempt failed, since the last successful one.
+    consecutive_failures integer NOT NULL DEFAULT 0,
+    disabled_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+CREATE INDEX IF NOT EXISTS webhooks_events_idx ON webhooks USING GIN (events) WHERE active;
+
+-- One row per delivery attempt.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event_id text NOT NULL,
+    event text NOT NULL,
+    attempt integer NOT NULL,
+    attempted_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- NULL when no response was received.
+    status_code integer,
+    error text NOT NULL DEFAULT '',
+    duration_ms integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+
+INSERT INTO permissions (code)
+VALUES
+    ('webhooks:write')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS webhook_pending_deliveries;
+DROP TRIGGER IF EXISTS outbox_events_notify ON outbox_events;
+DROP FUNCTION IF EXISTS notify_outbox_events();
+DROP TABLE IF EXISTS outbox_checkpoints;
+DROP TABLE IF EXISTS outbox_events;
@@ -0,0 +1,47 @@
+-- Change events, written in the same transaction as the change itself (the transactional outbox).
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    event text NOT NULL,
+    payload jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_created_at_idx ON outbox_events (created_at);
+
+-- How far each (durable) subscriber got.
+CREATE TABLE IF NOT EXISTS outbox_checkpoints (
+    subscriber text PRIMARY KEY,
+    last_event_id bigint NOT NULL,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Wakes up the relays; notifications are only delivered once the transaction commits.
+CREATE OR REPLACE FUNCTION notify_outbox_events() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('outbox_events', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_events_notify
+    AFTER INSERT ON outbox_events
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_outbox_events();
+
+-- The deliveries still to be made: queued in the transaction moving the "webhooks" outbox checkpoint,
+-- & deleted once the webhook accepts the event or the attempts are used up. They survive restarts.
+CREATE TABLE IF NOT EXISTS webhook_pending_deliveries (
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event_id text NOT NULL,
+    event text NOT NULL,
+    -- The request body, as signed & sent on every attempt.
+    body bytea NOT NULL,
+    -- The attempts made so far.
+    attempts integer NOT NULL DEFAULT 0,
+    -- Pushed into the future while an instance is attempting the delivery (its lease), & by the retry delay.
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_pending_deliveries_next_attempt_at_idx ON webhook_pending_deliveries (next_attempt_at);
@@ -0,0 +1,6 @@
+-- Required for every change to the movies: creating, updating, deleting & reverting them
+-- (on their own or in a batch), as well as their posters & translations.
+INSERT INTO permissions (code)
+VALUES
+    ('movies:write')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,20 @@
+-- The responses to POST requests sent with an Idempotency-Key header, replayed on retries.
+-- Keys are scoped per user. Anonymous requests are kept under user_id 0, their keys suffixed with
+-- the request's fingerprint, so different clients using the same key don't see each other's responses.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id bigint NOT NULL,
+    key text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- A hash of the method, path & body; a key can't be reused for another request.
+    fingerprint bytea NOT NULL,
+    -- NULL while the first request is in flight.
+    status_code integer,
+    header jsonb,
+    body bytea,
+    -- An in-flight request not completed by then is considered abandoned (e.g. the server crashed).
+    locked_until timestamp(0) with time zone NOT NULL,
+    expires_at timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS movies_normalized_title_year_idx;
+DROP FUNCTION IF EXISTS normalize_title(text);
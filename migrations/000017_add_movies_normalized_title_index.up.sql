@@ -0,0 +1,7 @@
+-- Titles as compared when looking for duplicate movies: lowercase, with every run of punctuation
+-- & whitespace turned into a single space, e.g. "Star Wars: A New Hope!" => "star wars a new hope".
+CREATE OR REPLACE FUNCTION normalize_title(title text) RETURNS text AS $$
+    SELECT btrim(regexp_replace(lower(title), '[^[:alnum:]]+', ' ', 'g'))
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+CREATE INDEX IF NOT EXISTS movies_normalized_title_year_idx ON movies (normalize_title(title), year);
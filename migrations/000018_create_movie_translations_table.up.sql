@@ -0,0 +1,14 @@
+-- The titles (& optional overviews) of a movie in other languages; "locale" is a language tag like "de" or "pt-BR".
+CREATE TABLE IF NOT EXISTS movie_translations (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    locale text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    overview text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    PRIMARY KEY (movie_id, locale)
+);
+
+-- The title searches cover the translated titles too (full-text & fuzzy).
+CREATE INDEX IF NOT EXISTS movie_translations_title_tsv_idx ON movie_translations USING GIN (to_tsvector('simple', title));
+CREATE INDEX IF NOT EXISTS movie_translations_title_trgm_idx ON movie_translations USING GIN (title gin_trgm_ops);
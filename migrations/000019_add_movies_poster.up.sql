@@ -0,0 +1,2 @@
+-- The storage key of the movie's poster image (its thumbnails are derived from it); '' without a poster.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster text NOT NULL DEFAULT '';
@@ -0,0 +1,228 @@
+// Package moviesclient is a Go client for the movies API.
+//
+// It takes care of the envelopes wrapping every request & response, the "102 mins" runtime
+// encoding & the error responses, which are turned into typed errors:
+//
+//	client := moviesclient.New("http://localhost:4000", moviesclient.WithToken(token))
+//
+//	movie, err := client.GetMovie(ctx, 1)
+//	if errors.Is(err, moviesclient.ErrNotFound) {
+//		...
+//	}
+//
+// Idempotent requests (GET, PUT & DELETE) are retried with exponential backoff
+// when the connection fails or the server is temporarily unavailable.
+package moviesclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Client is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+type Option func(*Client)
+
+// Sets the HTTP client used for requests (http.DefaultClient otherwise).
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// Authenticates every request with the bearer token (see POST /v1/tokens/authentication).
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// Sets how often a failed idempotent request is retried (default 3) & the delay before the first
+// retry (default 200ms); the delay doubles on every further attempt. maxRetries = 0 disables retries.
+func WithRetries(maxRetries int, baseDelay time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.baseDelay = baseDelay
+	}
+}
+
+// Returns a client for the API at baseURL, e.g. "http://localhost:4000".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		maxRetries: 3,
+		baseDelay:  200 * time.Millisecond,
+		maxDelay:   5 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// A request to the API; body (if any) is encoded as JSON.
+type request struct {
+	method  string
+	path    string
+	query   url.Values
+	body    any
+	headers http.Header
+}
+
+// Sends the request, retrying where that's safe, & decodes the response envelope into dst.
+// Error responses are returned as *Error or *ValidationError.
+func (c *Client) do(ctx context.Context, req request, dst any) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return err
+		}
+	}
+
+	u := c.baseURL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+
+	retries := 0
+	if isIdempotent(req.method) {
+		retries = c.maxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req, u, body)
+
+		if attempt < retries && shouldRetry(ctx, resp, err) {
+			delay := c.backoff(attempt, resp)
+			if resp != nil {
+				// Drain the body, so the connection can be reused.
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return decodeResponse(resp, dst)
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, u string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u, r)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range req.headers {
+		httpReq.Header[key] = values
+	}
+
+	httpReq.Header.Set("Accept", "application/json")
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	return c.httpClient.Do(httpReq)
+}
+
+// POST & PATCH requests aren't retried: if the first attempt did go through,
+// a retry would e.g. create a second movie.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// Connection failures & the statuses of an overloaded or restarting server are worth another try.
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// Giving up was the caller's decision.
+		return ctx.Err() == nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// Exponential backoff with jitter; a Retry-After header (in seconds) takes precedence.
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, c.maxDelay)
+		}
+	}
+
+	delay := min(c.baseDelay<<attempt, c.maxDelay)
+	if delay <= 0 {
+		return 0
+	}
+
+	// Somewhere between half & all of the delay, so clients don't retry in lockstep.
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func decodeResponse(resp *http.Response, dst any) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+
+	if dst == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	err := json.NewDecoder(resp.Body).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("moviesclient: decoding response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,431 @@
+package moviesclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Starts a test server & returns a client for it which retries without waiting.
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	opts = append([]Option{WithHTTPClient(srv.Client()), WithRetries(3, time.Millisecond)}, opts...)
+	return New(srv.URL, opts...)
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, status int, body any) {
+	t.Helper()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetMovie(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/movies/7" {
+			t.Errorf("got %s %s; want GET /v1/movies/7", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q; want %q", got, "Bearer secret")
+		}
+
+		io.WriteString(w, `{"movie": {"id": 7, "title": "Casablanca", "year": 1942, "runtime": "102 mins", "genres": ["drama"], "version": 3}}`)
+	}, WithToken("secret"))
+
+	movie, err := client.GetMovie(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if movie.ID != 7 || movie.Title != "Casablanca" || movie.Runtime != 102 || movie.Version != 3 {
+		t.Errorf("got %+v", movie)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		body   string
+		check  func(t *testing.T, err error)
+	}{
+		{
+			name:   "not found",
+			status: http.StatusNotFound,
+			body:   `{"error": "The requested resource could not be found."}`,
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("got %v; want ErrNotFound", err)
+				}
+			},
+		},
+		{
+			name:   "edit conflict",
+			status: http.StatusConflict,
+			body:   `{"error": "Unable to update the record due to an edit conflict; please fetch it & try again."}`,
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, ErrEditConflict) {
+					t.Errorf("got %v; want ErrEditConflict", err)
+				}
+				if errors.Is(err, ErrDuplicate) {
+					t.Error("an edit conflict must not match ErrDuplicate")
+				}
+			},
+		},
+		{
+			name:   "duplicate",
+			status: http.StatusConflict,
+			body:   `{"error": "A movie with the same title & year exists already.", "duplicates": [{"id": 7, "title": "Heat", "year": 1995, "url": "/v1/movies/7"}, {"id": 9, "title": "Heat!", "year": 1995, "url": "/v1/movies/9"}]}`,
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, ErrDuplicate) {
+					t.Errorf("got %v; want ErrDuplicate", err)
+				}
+				if errors.Is(err, ErrEditConflict) {
+					t.Error("a duplicate must not match ErrEditConflict")
+				}
+
+				var apiErr *Error
+				if !errors.As(err, &apiErr) || !slices.Equal(apiErr.Duplicates, []int64{7, 9}) {
+					t.Errorf("got %+v; want the duplicates 7 & 9", apiErr)
+				}
+			},
+		},
+		{
+			// E.g. a CSV or XML error body; the Link headers still name the duplicates.
+			name:   "duplicate links",
+			status: http.StatusConflict,
+			header: http.Header{"Link": {`</v1/movies/7>; rel="duplicate"`, `</v1/movies?page=2>; rel="next", </v1/movies/9>; rel="duplicate"`}},
+			body:   `error: A movie with the same title & year exists already.`,
+			check: func(t *testing.T, err error) {
+				var apiErr *Error
+				if !errors.As(err, &apiErr) || !slices.Equal(apiErr.Duplicates, []int64{7, 9}) {
+					t.Errorf("got %+v; want the duplicates 7 & 9", apiErr)
+				}
+				if !errors.Is(err, ErrDuplicate) || errors.Is(err, ErrEditConflict) {
+					t.Errorf("got %v; want ErrDuplicate only", err)
+				}
+			},
+		},
+		{
+			name:   "validation",
+			status: http.StatusUnprocessableEntity,
+			body:   `{"error": {"title": "must be provided", "year": "must not be in the future"}}`,
+			check: func(t *testing.T, err error) {
+				var verr *ValidationError
+				if !errors.As(err, &verr) {
+					t.Fatalf("got %v; want a *ValidationError", err)
+				}
+				if verr.Fields["year"] != "must not be in the future" || len(verr.Fields) != 2 {
+					t.Errorf("Fields = %v", verr.Fields)
+				}
+			},
+		},
+		{
+			name:   "not an envelope",
+			status: http.StatusBadRequest,
+			body:   `bad request`,
+			check: func(t *testing.T, err error) {
+				var apiErr *Error
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("got %v; want an *Error", err)
+				}
+				if apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "bad request" {
+					t.Errorf("got %+v", apiErr)
+				}
+				if errors.Is(err, ErrNotFound) {
+					t.Error("a 400 must not match ErrNotFound")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				for key, values := range tt.header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+
+			_, err := client.UpdateMovie(context.Background(), 1, 1, MovieUpdate{})
+			tt.check(t, err)
+		})
+	}
+}
+
+func TestCreateMovieSendsMinutes(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]any
+
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The API expects a plain number of minutes.
+		if input["runtime"] != float64(102) {
+			t.Errorf("runtime = %v; want 102", input["runtime"])
+		}
+
+		writeJSON(t, w, http.StatusCreated, map[string]any{"movie": map[string]any{"id": 1, "title": input["title"], "runtime": "102 mins", "version": 1}})
+	})
+
+	movie, err := client.CreateMovie(context.Background(), MovieInput{Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if movie.ID != 1 || movie.Runtime != 102 {
+		t.Errorf("got %+v", movie)
+	}
+}
+
+func TestCreateMovieAllowDuplicate(t *testing.T) {
+	tests := []struct {
+		allow bool
+		query string
+	}{
+		{allow: false, query: ""},
+		{allow: true, query: "allow_duplicate=true"},
+	}
+
+	for _, tt := range tests {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.RawQuery != tt.query {
+				t.Errorf("AllowDuplicate %t: query = %q; want %q", tt.allow, r.URL.RawQuery, tt.query)
+			}
+
+			// The option only goes into the query.
+			body, _ := io.ReadAll(r.Body)
+			if strings.Contains(string(body), "allow") {
+				t.Errorf("body = %s; want no allow_duplicate", body)
+			}
+
+			writeJSON(t, w, http.StatusCreated, map[string]any{"movie": map[string]any{"id": 1, "title": "Heat", "version": 1}})
+		})
+
+		_, err := client.CreateMovie(context.Background(), MovieInput{Title: "Heat", Year: 1995, Runtime: 170, AllowDuplicate: tt.allow})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUpdateMovieSendsExpectedVersion(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Expected-Version"); got != "4" {
+			t.Errorf("X-Expected-Version = %q; want 4", got)
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"title":"New title"}` {
+			t.Errorf("body = %s; want only the changed field", body)
+		}
+
+		io.WriteString(w, `{"movie": {"id": 1, "title": "New title", "version": 5}}`)
+	})
+
+	title := "New title"
+	movie, err := client.UpdateMovie(context.Background(), 1, 4, MovieUpdate{Title: &title})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if movie.Version != 5 {
+		t.Errorf("Version = %d; want 5", movie.Version)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		io.WriteString(w, `{"movie": {"id": 1}}`)
+	})
+
+	_, err := client.GetMovie(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls.Load() != 3 {
+		t.Errorf("got %d calls; want 3", calls.Load())
+	}
+}
+
+func TestRetriesGiveUp(t *testing.T) {
+	var calls atomic.Int32
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	err := client.DeleteMovie(context.Background(), 1)
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("got %v; want a 502 *Error", err)
+	}
+
+	// The first attempt plus 3 retries.
+	if calls.Load() != 4 {
+		t.Errorf("got %d calls; want 4", calls.Load())
+	}
+}
+
+func TestNoRetryForPost(t *testing.T) {
+	var calls atomic.Int32
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	_, err := client.CreateMovie(context.Background(), MovieInput{Title: "Casablanca"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("got %d calls; want 1", calls.Load())
+	}
+}
+
+func TestListMoviesOptions(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		want := "genres=drama%2Ccrime&page=2&page_size=5&runtime_max=120&sort=-year&title=god&year_min=1970"
+		if got := r.URL.RawQuery; got != want {
+			t.Errorf("query = %s; want %s", got, want)
+		}
+
+		io.WriteString(w, `{"movies": [{"id": 1}, {"id": 2}], "metadata": {"current_page": 2, "page_size": 5, "last_page": 2, "total_records": 7}}`)
+	})
+
+	movies, metadata, err := client.ListMovies(context.Background(), ListOptions{
+		Title:      "god",
+		Genres:     []string{"drama", "crime"},
+		YearMin:    1970,
+		RuntimeMax: 120,
+		Sort:       "-year",
+		Page:       2,
+		PageSize:   5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(movies) != 2 || metadata.TotalRecords != 7 {
+		t.Errorf("got %d movies & %+v", len(movies), metadata)
+	}
+}
+
+func TestAllMovies(t *testing.T) {
+	// Three pages: the first two are linked by cursors, the last one is reached by page number.
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+
+		switch {
+		case qs.Get("cursor") == "" && qs.Get("page") == "":
+			io.WriteString(w, `{"movies": [{"id": 1}, {"id": 2}], "metadata": {"current_page": 1, "last_page": 3, "next_cursor": "c2"}}`)
+		case qs.Get("cursor") == "c2":
+			io.WriteString(w, `{"movies": [{"id": 3}, {"id": 4}], "metadata": {"current_page": 2, "last_page": 3}}`)
+		case qs.Get("page") == "3":
+			io.WriteString(w, `{"movies": [{"id": 5}], "metadata": {"current_page": 3, "last_page": 3}}`)
+		default:
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	var ids []int64
+	for movie, err := range client.AllMovies(context.Background(), ListOptions{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, movie.ID)
+	}
+
+	if len(ids) != 5 {
+		t.Fatalf("got ids %v; want 1 to 5", ids)
+	}
+	for i, id := range ids {
+		if id != int64(i+1) {
+			t.Errorf("ids[%d] = %d; want %d", i, id, i+1)
+		}
+	}
+}
+
+func TestAllMoviesStopsOnError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, `{"error": {"page": "must be a maximum of 10 million"}}`)
+			return
+		}
+
+		io.WriteString(w, `{"movies": [{"id": 1}], "metadata": {"current_page": 1, "last_page": 2}}`)
+	})
+
+	var got int
+	var lastErr error
+	for _, err := range client.AllMovies(context.Background(), ListOptions{}) {
+		if err != nil {
+			lastErr = err
+			break
+		}
+		got++
+	}
+
+	var verr *ValidationError
+	if got != 1 || !errors.As(lastErr, &verr) {
+		t.Errorf("got %d movies & error %v", got, lastErr)
+	}
+}
+
+func TestRuntimeJSON(t *testing.T) {
+	for _, input := range []string{`"102 mins"`, `102`, `"102"`} {
+		var r Runtime
+
+		err := json.Unmarshal([]byte(input), &r)
+		if err != nil || r != 102 {
+			t.Errorf("Unmarshal(%s) = %d, %v; want 102", input, r, err)
+		}
+	}
+
+	var r Runtime
+	if err := json.Unmarshal([]byte(`"long"`), &r); err == nil {
+		t.Error("expected an error for an invalid runtime")
+	}
+
+	js, _ := json.Marshal(Runtime(95))
+	if n, err := strconv.Atoi(string(js)); err != nil || n != 95 {
+		t.Errorf("Marshal = %s; want 95", js)
+	}
+}
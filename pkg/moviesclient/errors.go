@@ -0,0 +1,141 @@
+package moviesclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Use errors.Is to check for these, e.g. errors.Is(err, moviesclient.ErrNotFound).
+var (
+	ErrNotFound = errors.New("moviesclient: not found")
+	// The movie was changed by someone else since it was read; fetch it again & reapply the changes.
+	ErrEditConflict = errors.New("moviesclient: edit conflict")
+	// The new movie looks like one which exists already (the same year & a very similar title);
+	// Error.Duplicates holds the ids of those. Set MovieInput.AllowDuplicate to create it anyway.
+	ErrDuplicate = errors.New("moviesclient: duplicate movie")
+)
+
+// An error response from the API, e.g. {"error": "The requested resource could not be found."}
+type Error struct {
+	StatusCode int
+	Message    string
+	// The ids of the existing movies a new one would duplicate (only with ErrDuplicate).
+	Duplicates []int64
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("moviesclient: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrEditConflict:
+		// Both are reported as 409s; only duplicates list the movies they conflict with.
+		return e.StatusCode == http.StatusConflict && len(e.Duplicates) == 0
+	case ErrDuplicate:
+		return e.StatusCode == http.StatusConflict && len(e.Duplicates) > 0
+	default:
+		return false
+	}
+}
+
+// The input failed validation (422); Fields maps the field names to what's wrong with them,
+// e.g. {"year": "must not be in the future"}.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + " " + e.Fields[key]
+	}
+
+	return "moviesclient: validation failed: " + strings.Join(parts, "; ")
+}
+
+// Decodes an error envelope: {"error": "message"} or {"error": {"field": "message"}}.
+// A duplicate movie's envelope also lists the existing movies: {"error": "...", "duplicates": [...]}.
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	var envelope struct {
+		Error      json.RawMessage `json:"error"`
+		Duplicates []struct {
+			ID int64 `json:"id"`
+		} `json:"duplicates"`
+	}
+
+	var message string
+	var fields map[string]string
+
+	err := json.Unmarshal(body, &envelope)
+	switch {
+	case err != nil || envelope.Error == nil:
+		// Not one of ours, e.g. from a proxy in between.
+		message = strings.TrimSpace(string(body))
+		if message == "" {
+			message = http.StatusText(resp.StatusCode)
+		}
+	case json.Unmarshal(envelope.Error, &message) == nil:
+	case json.Unmarshal(envelope.Error, &fields) == nil:
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			return &ValidationError{Fields: fields}
+		}
+		message = (&ValidationError{Fields: fields}).Error()
+	default:
+		message = string(envelope.Error)
+	}
+
+	apiErr := &Error{StatusCode: resp.StatusCode, Message: message}
+
+	if resp.StatusCode == http.StatusConflict {
+		for _, duplicate := range envelope.Duplicates {
+			apiErr.Duplicates = append(apiErr.Duplicates, duplicate.ID)
+		}
+
+		// Without the body (e.g. in another format), the Link headers name them as well.
+		if len(apiErr.Duplicates) == 0 {
+			apiErr.Duplicates = duplicateLinks(resp.Header)
+		}
+	}
+
+	return apiErr
+}
+
+// The ids of the movies linked with rel="duplicate", e.g. `</v1/movies/7>; rel="duplicate"`.
+func duplicateLinks(header http.Header) []int64 {
+	var ids []int64
+
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok || !strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="duplicate"`) {
+				continue
+			}
+
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+
+			id, err := strconv.ParseInt(strings.TrimPrefix(target, "/v1/movies/"), 10, 64)
+			if err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,27 @@
+package moviesclient
+
+import (
+	"context"
+	"net/http"
+)
+
+type Genre struct {
+	Slug       string   `json:"slug"`
+	Name       string   `json:"name"`
+	Aliases    []string `json:"aliases"`
+	MovieCount int64    `json:"movie_count"`
+}
+
+// Lists every genre with its aliases; movies may be tagged with either.
+func (c *Client) ListGenres(ctx context.Context) ([]*Genre, error) {
+	var resp struct {
+		Genres []*Genre `json:"genres"`
+	}
+
+	err := c.do(ctx, request{method: http.MethodGet, path: "/v1/genres"}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Genres, nil
+}
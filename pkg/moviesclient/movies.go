@@ -0,0 +1,244 @@
+package moviesclient
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A movie runtime in minutes.
+// The API sends runtimes as "102 mins" but expects a plain number of minutes in requests;
+// Runtime handles both.
+type Runtime int32
+
+func (r Runtime) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Itoa(int(r))), nil
+}
+
+// Accepts "102 mins" as well as 102.
+func (r *Runtime) UnmarshalJSON(js []byte) error {
+	s := string(js)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = strings.TrimSpace(strings.TrimSuffix(unquoted, "mins"))
+	}
+
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return fmt.Errorf("moviesclient: invalid runtime %s", js)
+	}
+
+	*r = Runtime(i)
+	return nil
+}
+
+type Movie struct {
+	ID            int64    `json:"id"`
+	Title         string   `json:"title"`
+	Year          int32    `json:"year"`
+	Runtime       Runtime  `json:"runtime"`
+	Genres        []string `json:"genres"`
+	Version       int32    `json:"version"`
+	AverageRating float64  `json:"average_rating"`
+	RatingCount   int64    `json:"rating_count"`
+	// Only set by title searches: the HTML-escaped title with the matches wrapped in <mark> tags.
+	Highlight string `json:"highlight,omitempty"`
+	// Only set for movies with a poster; the thumbnails are keyed by width, e.g. "w185".
+	PosterURL           string            `json:"poster_url,omitempty"`
+	PosterThumbnailURLs map[string]string `json:"poster_thumbnail_urls,omitempty"`
+}
+
+// The fields of a new movie; all are required.
+type MovieInput struct {
+	Title   string   `json:"title"`
+	Year    int32    `json:"year"`
+	Runtime Runtime  `json:"runtime"`
+	Genres  []string `json:"genres"`
+	// Create the movie even if it looks like one which exists already (see ErrDuplicate).
+	AllowDuplicate bool `json:"-"`
+}
+
+// A partial update; only the non-nil fields are changed.
+type MovieUpdate struct {
+	Title   *string  `json:"title,omitempty"`
+	Year    *int32   `json:"year,omitempty"`
+	Runtime *Runtime `json:"runtime,omitempty"`
+	Genres  []string `json:"genres,omitempty"`
+}
+
+// Pagination details of a list; with cursor pagination only PageSize & the cursors are set.
+type Metadata struct {
+	CurrentPage  int    `json:"current_page"`
+	PageSize     int    `json:"page_size"`
+	FirstPage    int    `json:"first_page"`
+	LastPage     int    `json:"last_page"`
+	TotalRecords int    `json:"total_records"`
+	NextCursor   string `json:"next_cursor"`
+	PrevCursor   string `json:"prev_cursor"`
+}
+
+// Filters, sorting & paging for ListMovies; the zero value lists the first page sorted by id.
+type ListOptions struct {
+	Title    string
+	Fuzzy    bool     // typo-tolerant title matching
+	Genres   []string // by default movies must have all of them
+	AnyGenre bool     // ...or any of them
+	PersonID int64    // only movies crediting this person
+	// Inclusive ranges; 0 means unbounded.
+	YearMin    int
+	YearMax    int
+	RuntimeMin Runtime
+	RuntimeMax Runtime
+	// e.g. "title" or "-year" (descending).
+	Sort     string
+	Page     int
+	PageSize int
+	// A NextCursor/PrevCursor from the Metadata of a previous page; replaces Page.
+	Cursor string
+}
+
+func (o ListOptions) values() url.Values {
+	qs := url.Values{}
+
+	set := func(key, value string, ok bool) {
+		if ok {
+			qs.Set(key, value)
+		}
+	}
+
+	set("title", o.Title, o.Title != "")
+	set("match", "fuzzy", o.Fuzzy)
+	set("genres", strings.Join(o.Genres, ","), len(o.Genres) > 0)
+	set("any_genre", "true", o.AnyGenre)
+	set("person", strconv.FormatInt(o.PersonID, 10), o.PersonID != 0)
+	set("year_min", strconv.Itoa(o.YearMin), o.YearMin != 0)
+	set("year_max", strconv.Itoa(o.YearMax), o.YearMax != 0)
+	set("runtime_min", strconv.Itoa(int(o.RuntimeMin)), o.RuntimeMin != 0)
+	set("runtime_max", strconv.Itoa(int(o.RuntimeMax)), o.RuntimeMax != 0)
+	set("sort", o.Sort, o.Sort != "")
+	set("page", strconv.Itoa(o.Page), o.Page != 0)
+	set("page_size", strconv.Itoa(o.PageSize), o.PageSize != 0)
+	set("cursor", o.Cursor, o.Cursor != "")
+
+	return qs
+}
+
+// Returns ErrDuplicate (via errors.Is) if the movie looks like one which exists already,
+// unless input.AllowDuplicate is set.
+func (c *Client) CreateMovie(ctx context.Context, input MovieInput) (*Movie, error) {
+	var resp struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	req := request{method: http.MethodPost, path: "/v1/movies", body: input}
+	if input.AllowDuplicate {
+		req.query = url.Values{"allow_duplicate": {"true"}}
+	}
+
+	err := c.do(ctx, req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Movie, nil
+}
+
+// Returns ErrNotFound (via errors.Is) if there's no such movie.
+func (c *Client) GetMovie(ctx context.Context, id int64) (*Movie, error) {
+	var resp struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/v1/movies/%d", id)}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Movie, nil
+}
+
+// Applies the update only if the movie is still at the given version (i.e. the one the changes
+// were based on); otherwise ErrEditConflict is returned. A version of 0 skips that check.
+func (c *Client) UpdateMovie(ctx context.Context, id int64, version int32, update MovieUpdate) (*Movie, error) {
+	req := request{
+		method:  http.MethodPatch,
+		path:    fmt.Sprintf("/v1/movies/%d", id),
+		body:    update,
+		headers: http.Header{},
+	}
+
+	if version > 0 {
+		req.headers.Set("X-Expected-Version", strconv.Itoa(int(version)))
+	}
+
+	var resp struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	err := c.do(ctx, req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Movie, nil
+}
+
+func (c *Client) DeleteMovie(ctx context.Context, id int64) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/v1/movies/%d", id)}, nil)
+}
+
+// Returns a single page of movies; see AllMovies for going through all of them.
+func (c *Client) ListMovies(ctx context.Context, opts ListOptions) ([]*Movie, Metadata, error) {
+	var resp struct {
+		Movies   []*Movie `json:"movies"`
+		Metadata Metadata `json:"metadata"`
+	}
+
+	err := c.do(ctx, request{method: http.MethodGet, path: "/v1/movies", query: opts.values()}, &resp)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return resp.Movies, resp.Metadata, nil
+}
+
+// Iterates over every movie matching the options, fetching the pages as it goes:
+//
+//	for movie, err := range client.AllMovies(ctx, moviesclient.ListOptions{Genres: []string{"drama"}}) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// It follows the next cursors (falling back to page numbers), so rows aren't skipped
+// or repeated if movies are added or removed meanwhile. Iteration stops at the first error.
+func (c *Client) AllMovies(ctx context.Context, opts ListOptions) iter.Seq2[*Movie, error] {
+	return func(yield func(*Movie, error) bool) {
+		for {
+			movies, metadata, err := c.ListMovies(ctx, opts)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, movie := range movies {
+				if !yield(movie, nil) {
+					return
+				}
+			}
+
+			switch {
+			case metadata.NextCursor != "":
+				opts.Cursor, opts.Page = metadata.NextCursor, 0
+			case metadata.CurrentPage > 0 && metadata.CurrentPage < metadata.LastPage:
+				opts.Cursor, opts.Page = "", metadata.CurrentPage+1
+			default:
+				return
+			}
+		}
+	}
+}